@@ -0,0 +1,166 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path"
+)
+
+// EnvPodIP is the environment variable holding the address of the pod in kubernetes.
+const EnvPodIP = "POD_IP"
+
+// AddressOptions selects the local address registered when the host of registry.Info is empty or unspecified.
+// The address is taken from the environment first, then from the outbound interface, then from the network interfaces.
+type AddressOptions struct {
+	// EnvKeys are the environment variables holding the address, checked in order, POD_IP if empty.
+	EnvKeys []string
+	// OutboundTarget is an address, such as the one of the polaris server, used to find the interface
+	// the routing table sends the traffic through, without sending any packet. Empty disables the lookup.
+	OutboundTarget string
+	// Interfaces are the only network interfaces used if not empty, path.Match patterns such as "eth*".
+	Interfaces []string
+	// ExcludeInterfaces are the network interfaces never used, path.Match patterns such as "docker*".
+	ExcludeInterfaces []string
+	// PreferredCIDRs are the networks whose addresses are preferred, in order.
+	PreferredCIDRs []string
+	// PreferIPv6 prefers the IPv6 addresses, the IPv4 ones are preferred by default.
+	// An address of the other family is used when there is no preferred one.
+	PreferIPv6 bool
+}
+
+// localAddr is an address of a network interface.
+type localAddr struct {
+	iface string
+	ip    net.IP
+}
+
+// GetLocalAddress returns the local address selected by ao.
+func GetLocalAddress(ao AddressOptions) (string, error) {
+	keys := ao.EnvKeys
+	if len(keys) == 0 {
+		keys = []string{EnvPodIP}
+	}
+	for _, key := range keys {
+		if ip := net.ParseIP(os.Getenv(key)); ip != nil {
+			return ip.String(), nil
+		}
+	}
+
+	candidates, err := interfaceAddrs(ao)
+	if err != nil {
+		return "", err
+	}
+	if len(ao.OutboundTarget) != 0 {
+		if ip, err := outboundIP(ao.OutboundTarget); err == nil && containsIP(candidates, ip) {
+			return ip.String(), nil
+		}
+	}
+	ip, err := selectAddress(candidates, ao)
+	if err != nil {
+		return "", err
+	}
+	return ip.String(), nil
+}
+
+// interfaceAddrs returns the addresses of the running network interfaces allowed by ao, except the loopback
+// and the link local ones.
+func interfaceAddrs(ao AddressOptions) ([]localAddr, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var addrs []localAddr
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || !ao.allowInterface(iface.Name) {
+			continue
+		}
+		ifaceAddrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range ifaceAddrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			addrs = append(addrs, localAddr{iface: iface.Name, ip: ipNet.IP})
+		}
+	}
+	return addrs, nil
+}
+
+func (ao AddressOptions) allowInterface(name string) bool {
+	for _, pattern := range ao.ExcludeInterfaces {
+		if matched, _ := path.Match(pattern, name); matched {
+			return false
+		}
+	}
+	if len(ao.Interfaces) == 0 {
+		return true
+	}
+	for _, pattern := range ao.Interfaces {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// selectAddress picks the first address in the preferred networks, then the first one of the preferred family.
+func selectAddress(candidates []localAddr, ao AddressOptions) (net.IP, error) {
+	for _, cidr := range ao.PreferredCIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid preferred CIDR %q: %v", cidr, err)
+		}
+		for _, addr := range candidates {
+			if ipNet.Contains(addr.ip) {
+				return addr.ip, nil
+			}
+		}
+	}
+	for _, ipv6 := range []bool{ao.PreferIPv6, !ao.PreferIPv6} {
+		for _, addr := range candidates {
+			if (addr.ip.To4() == nil) == ipv6 {
+				return addr.ip, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("not found local address")
+}
+
+// outboundIP returns the local address the routing table uses to reach target, connecting UDP sends no packet.
+func outboundIP(target string) (net.IP, error) {
+	conn, err := net.Dial("udp", target)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+func containsIP(addrs []localAddr, ip net.IP) bool {
+	for _, addr := range addrs {
+		if addr.ip.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
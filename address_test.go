@@ -0,0 +1,90 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectAddress(t *testing.T) {
+	candidates := []localAddr{
+		{iface: "docker0", ip: net.ParseIP("172.17.0.1")},
+		{iface: "eth0", ip: net.ParseIP("2001:db8::10")},
+		{iface: "eth0", ip: net.ParseIP("10.0.0.10")},
+	}
+	ip, err := selectAddress(candidates, AddressOptions{})
+	require.Nil(t, err)
+	require.Equal(t, "172.17.0.1", ip.String())
+
+	ip, err = selectAddress(candidates, AddressOptions{PreferredCIDRs: []string{"192.168.0.0/16", "10.0.0.0/8"}})
+	require.Nil(t, err)
+	require.Equal(t, "10.0.0.10", ip.String())
+
+	ip, err = selectAddress(candidates, AddressOptions{PreferIPv6: true})
+	require.Nil(t, err)
+	require.Equal(t, "2001:db8::10", ip.String())
+
+	// an IPv6 only host
+	ip, err = selectAddress(candidates[1:2], AddressOptions{})
+	require.Nil(t, err)
+	require.Equal(t, "2001:db8::10", ip.String())
+
+	_, err = selectAddress(candidates, AddressOptions{PreferredCIDRs: []string{"10.0.0.0"}})
+	require.NotNil(t, err)
+	_, err = selectAddress(nil, AddressOptions{})
+	require.NotNil(t, err)
+}
+
+func TestAllowInterface(t *testing.T) {
+	ao := AddressOptions{ExcludeInterfaces: []string{"docker*", "veth*"}}
+	require.True(t, ao.allowInterface("eth0"))
+	require.False(t, ao.allowInterface("docker0"))
+	require.False(t, ao.allowInterface("vethd2f1"))
+
+	ao.Interfaces = []string{"eth*", "bond0"}
+	require.True(t, ao.allowInterface("eth1"))
+	require.True(t, ao.allowInterface("bond0"))
+	require.False(t, ao.allowInterface("tun0"))
+}
+
+func TestGetInfoHostAndPort(t *testing.T) {
+	defer os.Setenv(EnvPodIP, os.Getenv(EnvPodIP))
+	os.Setenv(EnvPodIP, "10.1.2.3")
+
+	for _, addr := range []string{":8888", "0.0.0.0:8888", "[::]:8888"} {
+		host, port, err := GetInfoHostAndPort(addr)
+		require.Nil(t, err)
+		require.Equal(t, "10.1.2.3", host)
+		require.Equal(t, 8888, port)
+	}
+	host, _, err := GetInfoHostAndPort("127.0.0.1:8888")
+	require.Nil(t, err)
+	require.Equal(t, "127.0.0.1", host)
+
+	os.Setenv("TEST_HOST_IP", "2001:db8::20")
+	defer os.Unsetenv("TEST_HOST_IP")
+	host, _, err = GetInfoHostAndPort(":8888", AddressOptions{EnvKeys: []string{"TEST_HOST_IP", EnvPodIP}})
+	require.Nil(t, err)
+	require.Equal(t, "2001:db8::20", host)
+
+	_, _, err = GetInfoHostAndPort("127.0.0.1")
+	require.NotNil(t, err)
+}
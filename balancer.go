@@ -18,14 +18,14 @@ package polaris
 
 import (
 	"context"
-	"strconv"
+	"fmt"
 	"sync"
+	"time"
 
 	"github.com/cloudwego/kitex/pkg/discovery"
 	"github.com/cloudwego/kitex/pkg/loadbalance"
 	"github.com/cloudwego/kitex/pkg/rpcinfo"
 	polarisgo "github.com/polarismesh/polaris-go"
-	"github.com/polarismesh/polaris-go/pkg/config"
 	"github.com/polarismesh/polaris-go/pkg/log"
 	"github.com/polarismesh/polaris-go/pkg/model"
 	"golang.org/x/sync/singleflight"
@@ -44,49 +44,194 @@ func newPolarisPicker() interface{} {
 type polarisPicker struct {
 	onceExecute         bool
 	routerAPI           polarisgo.RouterAPI
+	consumer            polarisgo.ConsumerAPI
+	bo                  BalancerOptions
 	info                *polarisInfo
-	routerInstancesResp *model.InstancesResponse
+	routerInstancesResp model.ServiceInstances
+	err                 error
+	// tried are the ids of the instances picked by the previous calls of Next.
+	tried []string
+}
+
+// pickErrorKey is the context key of the pickError set by NewUpdateServiceCallResultMW.
+type pickErrorKey struct{}
+
+// pickError holds why the picker returned no instance, which Kitex can't tell.
+type pickError struct {
+	err error
 }
 
 func (pp *polarisPicker) Next(ctx context.Context, request interface{}) (ins discovery.Instance) {
 	if !pp.onceExecute {
 		pp.onceExecute = true
+		pp.routerInstancesResp, pp.err = pp.route(ctx)
+	}
+	if pp.err != nil {
+		pp.fail(ctx, pp.err)
+		return nil
+	}
+
+	candidates, err := pp.candidates(ctx)
+	if err != nil {
+		pp.fail(ctx, err)
+		return nil
+	}
+	targetInstance, err := pp.loadBalance(ctx, request, candidates)
+	if err != nil {
+		pp.fail(ctx, err)
+		return nil
+	}
+	pp.tried = append(pp.tried, targetInstance.GetId())
+	if t := getTriedInstances(ctx); t != nil {
+		t.add(targetInstance.GetId())
+	}
+	ins, ok := pp.info.kitexInstances[targetInstance.GetId()]
+	if !ok {
+		// the instance is not in the result of the resolver, convert it as the resolver does
+		ins = ChangePolarisInstanceToKitex(targetInstance, pp.info.polarisOptions)
+	}
+	return ins
+}
 
-		routerRequest := &polarisgo.ProcessRoutersRequest{}
-		routerRequest.DstInstances = pp.info.cachedDstInstances
-		routerRequest.SourceService.Service = pp.info.polarisOptions.SrcService
-		routerRequest.SourceService.Namespace = pp.info.polarisOptions.SrcNamespace
-		routerRequest.SourceService.Metadata = pp.info.polarisOptions.SrcMetadata
-		ri := rpcinfo.GetRPCInfo(ctx)
+// route returns the instances selected by the routing rules for the request.
+func (pp *polarisPicker) route(ctx context.Context) (model.ServiceInstances, error) {
+	o := pp.info.polarisOptions
+	routerRequest := &polarisgo.ProcessRoutersRequest{}
+	routerRequest.DstInstances = pp.info.cachedDstInstances
+	routerRequest.SourceService.Service = o.SrcService
+	routerRequest.SourceService.Namespace = o.SrcNamespace
+	routerRequest.SourceService.Metadata = o.SrcLabels.srcMetadata(ctx, o.SrcMetadata)
+	var lane string
+	if o.Lane.Enable {
+		if lane = laneOf(ctx, o.Lane); lane != "" {
+			routerRequest.SourceService.Metadata = withLabel(routerRequest.SourceService.Metadata, o.Lane.metadataKey(), lane)
+		}
+	}
+	if ri := rpcinfo.GetRPCInfo(ctx); ri != nil {
 		routerRequest.Method = ri.To().Method()
+	}
+
+	if pp.bo.RouteCache.Disable {
+		return pp.processRouters(routerRequest, lane)
+	}
+	key := routeKey(routerRequest.Method, routerRequest.SourceService.Metadata)
+	dst := model.ServiceKey{Namespace: pp.info.namespace, Service: pp.info.serviceName}
+	src := model.ServiceKey{Namespace: o.SrcNamespace, Service: o.SrcService}
+	ruleRevision := routeRuleRevision(pp.consumer, dst, src)
+	now := time.Now()
+	if instances, ok := pp.info.routes.get(key, ruleRevision, now); ok {
+		return instances, nil
+	}
+	routed, err := pp.processRouters(routerRequest, lane)
+	if err != nil {
+		return nil, err
+	}
+	instances := model.NewDefaultServiceInstances(pp.info.svcInfo, routed.GetInstances())
+	entry := &routeEntry{instances: instances, ruleRevision: ruleRevision, expire: now.Add(pp.bo.RouteCache.ttl()).UnixNano()}
+	pp.info.routes.put(key, entry, pp.bo.RouteCache.maxEntries(), now)
+	return instances, nil
+}
+
+// processRouters applies the routing rules, then keeps the instances of the lane and the nearby ones.
+func (pp *polarisPicker) processRouters(routerRequest *polarisgo.ProcessRoutersRequest, lane string) (model.ServiceInstances, error) {
+	o := pp.info.polarisOptions
+	routerInstancesResp, err := pp.routerAPI.ProcessRouters(routerRequest)
+	if nil != err {
+		return nil, newPolarisError("ProcessRouters", pp.info.desc(), err)
+	}
+	instances := routerInstancesResp.GetInstances()
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("%w: %s has %d instances", ErrNoRoutedInstance, pp.info.desc(), len(pp.info.polarisInstances))
+	}
+	filtered := instances
+	if o.Lane.Enable {
+		if filtered, err = o.Lane.filter(pp.info.desc(), lane, filtered); err != nil {
+			return nil, err
+		}
+	}
+	if o.Nearby.Enable {
+		if filtered, err = o.Nearby.filter(pp.info.desc(), filtered); err != nil {
+			return nil, err
+		}
+	}
+	if len(filtered) != len(instances) {
+		return model.NewDefaultServiceInstances(pp.info.svcInfo, filtered), nil
+	}
+	return routerInstancesResp, nil
+}
+
+// fail logs why no instance is picked and reports it to NewUpdateServiceCallResultMW.
+func (pp *polarisPicker) fail(ctx context.Context, err error) {
+	log.GetBaseLogger().Errorf("polaris picker fails to pick an instance, err is %v", err)
+	if pe, ok := ctx.Value(pickErrorKey{}).(*pickError); ok {
+		pe.err = err
+	}
+}
 
-		var routerInstancesResp *model.InstancesResponse
-		var err error
-		routerInstancesResp, err = pp.routerAPI.ProcessRouters(routerRequest)
-		if nil != err {
-			log.GetBaseLogger().Errorf("fail to do ProcessRouters err:%+v", err)
-			return nil
+// candidates excludes the instances already tried in the RPC from the routed instances,
+// BalancerOptions.ExcludedFallback decides what to do when none is left.
+func (pp *polarisPicker) candidates(ctx context.Context) (model.ServiceInstances, error) {
+	tried := pp.tried
+	if t := getTriedInstances(ctx); t != nil {
+		if ids := t.snapshot(); ids != nil {
+			tried = append(ids, pp.tried...)
 		}
-		if len(routerInstancesResp.GetInstances()) == 0 {
-			return nil
+	}
+	if len(tried) == 0 {
+		return pp.routerInstancesResp, nil
+	}
+
+	instances := pp.routerInstancesResp.GetInstances()
+	remaining := make([]model.Instance, 0, len(instances))
+	for _, ins := range instances {
+		if !containsString(tried, ins.GetId()) {
+			remaining = append(remaining, ins)
 		}
+	}
+	switch {
+	case len(remaining) == len(instances):
+		return pp.routerInstancesResp, nil
+	case len(remaining) != 0:
+		return model.NewDefaultServiceInstances(pp.info.svcInfo, remaining), nil
+	case pp.bo.ExcludedFallback == ExcludedFallbackFail:
+		return nil, fmt.Errorf("polaris %s: %w", pp.info.desc(), ErrAllInstancesTried)
+	}
+	return pp.routerInstancesResp, nil
+}
 
-		pp.routerInstancesResp = routerInstancesResp
+// loadBalance picks one of the routed instances by the policy of the method.
+func (pp *polarisPicker) loadBalance(ctx context.Context, request interface{}, instances model.ServiceInstances) (model.Instance, error) {
+	var policy LBPolicy
+	if ri := rpcinfo.GetRPCInfo(ctx); ri != nil {
+		policy = pp.bo.policy(ri.To().Method())
+	} else {
+		policy = pp.bo.Policy
+	}
+	if policy.LoadBalancer == LBWeightedRoundRobin {
+		if ins := pp.info.wrr.next(instances.GetInstances()); ins != nil {
+			return ins, nil
+		}
+		return nil, fmt.Errorf("polaris %s: the %d instances all have weight 0", pp.info.desc(), len(instances.GetInstances()))
 	}
 
 	lbRequest := &polarisgo.ProcessLoadBalanceRequest{}
-	lbRequest.DstInstances = pp.routerInstancesResp
-	lbRequest.LbPolicy = config.DefaultLoadBalancerWR
+	lbRequest.DstInstances = instances
+	lbRequest.LbPolicy = LBWeightedRandom
+	if policy.LoadBalancer != "" && policy.LoadBalancer != LBWeightedRandom && policy.HashKey != nil {
+		if key := policy.HashKey(ctx, request); len(key) != 0 {
+			lbRequest.LbPolicy = policy.LoadBalancer
+			lbRequest.HashKey = key
+			lbRequest.ReplicateCount = policy.ReplicateCount
+		}
+	}
 	oneInstResp, err := pp.routerAPI.ProcessLoadBalance(lbRequest)
 	if nil != err {
-		return nil
+		return nil, newPolarisError("ProcessLoadBalance", pp.info.desc(), err)
 	}
-
-	targetInstance := oneInstResp.GetInstance()
-	addr := targetInstance.GetHost() + ":" + strconv.Itoa(int(targetInstance.GetPort()))
-	ins = pp.info.polarisInstanceMapKitexInstance[addr]
-
-	return ins
+	if oneInstResp.GetInstance() == nil {
+		return nil, fmt.Errorf("polaris ProcessLoadBalance %s: %s returned no instance", pp.info.desc(), lbRequest.LbPolicy)
+	}
+	return oneInstResp.GetInstance(), nil
 }
 
 func (pp *polarisPicker) Recycle() {
@@ -97,37 +242,64 @@ func (pp *polarisPicker) Recycle() {
 func (pp *polarisPicker) zero() {
 	pp.info = nil
 	pp.routerAPI = nil
+	pp.consumer = nil
+	pp.bo = BalancerOptions{}
 	pp.routerInstancesResp = nil
+	pp.err = nil
 	pp.onceExecute = false
+	pp.tried = pp.tried[:0]
+}
+
+// Balancer is extension interface of Kitex loadbalance.Loadbalancer.
+type Balancer interface {
+	loadbalance.Loadbalancer
+	loadbalance.Rebalancer
+	// Destroy releases the polaris client used by the balancer.
+	Destroy()
 }
 
 // polarisBalancer is a resolver using polaris.
 type polarisBalancer struct {
+	client            *clientRef
 	cachedPolarisInfo sync.Map
 	sfg               singleflight.Group
 	routerAPI         polarisgo.RouterAPI
+	consumer          polarisgo.ConsumerAPI
+	bo                BalancerOptions
+}
+
+// NewPolarisBalancer creates a polaris based balancer picking the instances by weighted random.
+func NewPolarisBalancer(opts ...Option) (Balancer, error) {
+	return NewPolarisBalancerWithOptions(BalancerOptions{}, opts...)
 }
 
-func NewPolarisBalancer(configFile ...string) (loadbalance.Loadbalancer, error) {
-	sdkCtx, err := GetPolarisConfig(configFile...)
+// NewPolarisBalancerWithOptions creates a polaris based balancer with the load balancing policies of bo.
+func NewPolarisBalancerWithOptions(bo BalancerOptions, opts ...Option) (Balancer, error) {
+	client, err := newClientRef(opts)
 	if err != nil {
 		return nil, err
 	}
 
 	pb := &polarisBalancer{
-		routerAPI: polarisgo.NewRouterAPIByContext(sdkCtx),
+		client:    client,
+		routerAPI: polarisgo.NewRouterAPIByContext(client.SDKContext()),
+		consumer:  polarisgo.NewConsumerAPIByContext(client.SDKContext()),
+		bo:        bo,
 	}
 
 	return pb, nil
 }
 
 type polarisInfo struct {
-	namespace                       string
-	serviceName                     string
-	polarisInstanceMapKitexInstance map[string]discovery.Instance
-	polarisInstances                []model.Instance
-	polarisOptions                  ClientOptions
-	cachedDstInstances              model.ServiceInstances
+	namespace          string
+	serviceName        string
+	kitexInstances     map[string]discovery.Instance // by polaris instance id
+	polarisInstances   []model.Instance
+	polarisOptions     ClientOptions
+	cachedDstInstances model.ServiceInstances
+	svcInfo            model.ServiceInfo
+	routes             routeCache
+	wrr                weightedRoundRobin
 }
 
 func (pb *polarisBalancer) GetPicker(e discovery.Result) loadbalance.Picker {
@@ -149,6 +321,8 @@ func (pb *polarisBalancer) GetPicker(e discovery.Result) loadbalance.Picker {
 	picker := polarisPickerPool.Get().(*polarisPicker)
 	picker.info = w
 	picker.routerAPI = pb.routerAPI
+	picker.consumer = pb.consumer
+	picker.bo = pb.bo
 
 	return picker
 }
@@ -173,10 +347,15 @@ func (pb *polarisBalancer) Delete(change discovery.Change) {
 	pb.cachedPolarisInfo.Delete(change.Result.CacheKey)
 }
 
+// Destroy implements the Balancer interface.
+func (pb *polarisBalancer) Destroy() {
+	pb.client.Destroy()
+}
+
 func (pb *polarisBalancer) newPolarisInfo(e discovery.Result) *polarisInfo {
 	pi := &polarisInfo{
-		polarisInstances:                make([]model.Instance, 0, len(e.Instances)),
-		polarisInstanceMapKitexInstance: make(map[string]discovery.Instance, 0),
+		polarisInstances: make([]model.Instance, 0, len(e.Instances)),
+		kitexInstances:   make(map[string]discovery.Instance, len(e.Instances)),
 	}
 	for _, kitexInst := range e.Instances {
 		pkInst, ok := kitexInst.(*polarisKitexInstance)
@@ -185,20 +364,23 @@ func (pb *polarisBalancer) newPolarisInfo(e discovery.Result) *polarisInfo {
 		}
 		pi.polarisOptions = pkInst.polarisOptions
 		pi.polarisInstances = append(pi.polarisInstances, pkInst.polarisInstance)
-		addr := pkInst.polarisInstance.GetHost() + ":" + strconv.Itoa(int(pkInst.polarisInstance.GetPort()))
-		pi.polarisInstanceMapKitexInstance[addr] = kitexInst
+		pi.kitexInstances[pkInst.polarisInstance.GetId()] = kitexInst
 	}
 
 	namespace, serviceName := SplitCachedKey(e.CacheKey)
 	pi.namespace = namespace
 	pi.serviceName = serviceName
 
-	svcInfo := model.ServiceInfo{
+	pi.svcInfo = model.ServiceInfo{
 		Service:   serviceName,
 		Namespace: namespace,
 		Metadata:  pi.polarisOptions.DstMetadata,
 	}
-	pi.cachedDstInstances = model.NewDefaultServiceInstances(svcInfo, pi.polarisInstances)
+	pi.cachedDstInstances = model.NewDefaultServiceInstances(pi.svcInfo, pi.polarisInstances)
 
 	return pi
 }
+
+func (pi *polarisInfo) desc() string {
+	return pi.namespace + ":" + pi.serviceName
+}
@@ -0,0 +1,582 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bytedance/gopkg/cloud/metainfo"
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/kerrors"
+	"github.com/cloudwego/kitex/pkg/loadbalance"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	namingpb "github.com/polarismesh/polaris-go/pkg/model/pb/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kitex-contrib/polaris/polaristest"
+)
+
+// resolveForBalancer resolves the service and names the result like the Kitex balancer cache does.
+func resolveForBalancer(t *testing.T, rs Resolver, svcName string) discovery.Result {
+	desc := rs.Target(context.TODO(), rpcinfo.NewEndpointInfo(svcName, "", nil, nil))
+	result, err := rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	result.CacheKey = rs.Name() + ":" + result.CacheKey
+	return result
+}
+
+func newRPCInfoCtx(svcName, method string) context.Context {
+	to := rpcinfo.NewEndpointInfo(svcName, method, nil, nil)
+	ri := rpcinfo.NewRPCInfo(nil, to, rpcinfo.NewInvocation(svcName, method), nil, nil)
+	return rpcinfo.NewCtxWithRPCInfo(context.Background(), ri)
+}
+
+func TestPolarisBalancer(t *testing.T) {
+	svcName := "balancer-test"
+	testServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{
+		Host: "127.0.0.1", Port: 9001, Metadata: map[string]string{"env": "base"},
+	})
+	testServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{
+		Host: "127.0.0.1", Port: 9002, Metadata: map[string]string{"env": "gray"},
+	})
+	// route all the traffic to the gray instance
+	testServer.SetRouting(DefaultPolarisNamespace, svcName, &namingpb.Routing{
+		Inbounds: []*namingpb.Route{{
+			Sources: []*namingpb.Source{{
+				Namespace: &wrappers.StringValue{Value: "*"},
+				Service:   &wrappers.StringValue{Value: "*"},
+			}},
+			Destinations: []*namingpb.Destination{{
+				Namespace: &wrappers.StringValue{Value: DefaultPolarisNamespace},
+				Service:   &wrappers.StringValue{Value: svcName},
+				Metadata: map[string]*namingpb.MatchString{
+					"env": {Value: &wrappers.StringValue{Value: "gray"}},
+				},
+				Weight: &wrappers.UInt32Value{Value: 100},
+			}},
+		}},
+	})
+
+	rs, err := NewPolarisResolver(ClientOptions{}, WithPolarisClient(testClient))
+	require.Nil(t, err)
+	lb, err := NewPolarisBalancer(WithPolarisClient(testClient))
+	require.Nil(t, err)
+
+	result := resolveForBalancer(t, rs, svcName)
+	require.Len(t, result.Instances, 2)
+	ctx := newRPCInfoCtx(svcName, "Echo")
+	for i := 0; i < 10; i++ {
+		picker := lb.GetPicker(result)
+		ins := picker.Next(ctx, nil)
+		require.NotNil(t, ins)
+		require.Equal(t, "127.0.0.1:9002", ins.Address().String())
+	}
+}
+
+func TestPolarisBalancerPolicies(t *testing.T) {
+	svcName := "balancer-policies"
+	testServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{Host: "127.0.0.1", Port: 9011, Weight: 100})
+	testServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{Host: "127.0.0.1", Port: 9012, Weight: 200})
+	testServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{Host: "127.0.0.1", Port: 9013, Weight: 100})
+
+	rs, err := NewPolarisResolver(ClientOptions{}, WithPolarisClient(testClient))
+	require.Nil(t, err)
+	bo := BalancerOptions{
+		Policy: LBPolicy{LoadBalancer: LBRingHash, HashKey: HashKeyByMetainfo("session")},
+		MethodPolicies: map[string]LBPolicy{
+			"Echo":  {LoadBalancer: LBWeightedRoundRobin},
+			"Hello": {LoadBalancer: LBMaglev, HashKey: HashKeyByMethod},
+		},
+	}
+	lb, err := NewPolarisBalancerWithOptions(bo, WithPolarisClient(testClient))
+	require.Nil(t, err)
+	result := resolveForBalancer(t, rs, svcName)
+	require.Len(t, result.Instances, 3)
+
+	// weighted round robin
+	ctx := newRPCInfoCtx(svcName, "Echo")
+	counts := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		counts[lb.GetPicker(result).Next(ctx, nil).Address().String()]++
+	}
+	require.Equal(t, map[string]int{"127.0.0.1:9011": 2, "127.0.0.1:9012": 4, "127.0.0.1:9013": 2}, counts)
+
+	// ring hash by session
+	ctx = newRPCInfoCtx(svcName, "Get")
+	picked := make(map[string]struct{})
+	for _, session := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		sessionCtx := metainfo.WithValue(ctx, "session", session)
+		addr := lb.GetPicker(result).Next(sessionCtx, nil).Address().String()
+		for i := 0; i < 5; i++ {
+			require.Equal(t, addr, lb.GetPicker(result).Next(sessionCtx, nil).Address().String())
+		}
+		picked[addr] = struct{}{}
+	}
+	require.True(t, len(picked) > 1)
+
+	// maglev by method
+	ctx = newRPCInfoCtx(svcName, "Hello")
+	addr := lb.GetPicker(result).Next(ctx, nil).Address().String()
+	for i := 0; i < 5; i++ {
+		require.Equal(t, addr, lb.GetPicker(result).Next(ctx, nil).Address().String())
+	}
+}
+
+func TestPolarisPickerFallback(t *testing.T) {
+	svcName := "balancer-fallback"
+	testServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{Host: "127.0.0.1", Port: 9021})
+
+	rs, err := NewPolarisResolver(ClientOptions{}, WithPolarisClient(testClient))
+	require.Nil(t, err)
+	lb, err := NewPolarisBalancer(WithPolarisClient(testClient))
+	require.Nil(t, err)
+	result := resolveForBalancer(t, rs, svcName)
+
+	picker := lb.GetPicker(result)
+	// the picked instance is missing from the instances of the result
+	for id := range picker.(*polarisPicker).info.kitexInstances {
+		delete(picker.(*polarisPicker).info.kitexInstances, id)
+	}
+	ins := picker.Next(newRPCInfoCtx(svcName, "Echo"), nil)
+	require.NotNil(t, ins)
+	require.Equal(t, "127.0.0.1:9021", ins.Address().String())
+	tag, _ := ins.Tag(NameSpaceTagKey)
+	require.Equal(t, DefaultPolarisNamespace, tag)
+}
+
+func TestPolarisPickerError(t *testing.T) {
+	svcName := "balancer-no-routed"
+	testServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{
+		Host: "127.0.0.1", Port: 9031, Metadata: map[string]string{"env": "base"},
+	})
+	// the rule matches no instance
+	testServer.SetRouting(DefaultPolarisNamespace, svcName, &namingpb.Routing{
+		Inbounds: []*namingpb.Route{{
+			Sources: []*namingpb.Source{{
+				Namespace: &wrappers.StringValue{Value: "*"},
+				Service:   &wrappers.StringValue{Value: "*"},
+			}},
+			Destinations: []*namingpb.Destination{{
+				Namespace: &wrappers.StringValue{Value: DefaultPolarisNamespace},
+				Service:   &wrappers.StringValue{Value: svcName},
+				Metadata: map[string]*namingpb.MatchString{
+					"env": {Value: &wrappers.StringValue{Value: "gray"}},
+				},
+				Weight: &wrappers.UInt32Value{Value: 100},
+			}},
+		}},
+	})
+
+	rs, err := NewPolarisResolver(ClientOptions{}, WithPolarisClient(testClient))
+	require.Nil(t, err)
+	lb, err := NewPolarisBalancer(WithPolarisClient(testClient))
+	require.Nil(t, err)
+	result := resolveForBalancer(t, rs, svcName)
+
+	// picks like the Kitex client does
+	pick := func(ctx context.Context, req, resp interface{}) error {
+		if lb.GetPicker(result).Next(ctx, req) == nil {
+			return kerrors.ErrNoMoreInstance.WithCause(errors.New("last error: <nil>"))
+		}
+		return nil
+	}
+	err = NewUpdateServiceCallResultMW(WithPolarisClient(testClient))(pick)(newRPCInfoCtx(svcName, "Echo"), nil, nil)
+	require.True(t, errors.Is(err, kerrors.ErrNoMoreInstance))
+	require.True(t, errors.Is(err, ErrNoRoutedInstance), err)
+}
+
+func TestPolarisPickerExcludeTried(t *testing.T) {
+	svcName := "balancer-retry"
+	for port := 9041; port <= 9043; port++ {
+		testServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{Host: "127.0.0.1", Port: port})
+	}
+
+	rs, err := NewPolarisResolver(ClientOptions{}, WithPolarisClient(testClient))
+	require.Nil(t, err)
+	result := resolveForBalancer(t, rs, svcName)
+
+	for _, lbName := range []string{LBWeightedRandom, LBRingHash, LBWeightedRoundRobin} {
+		bo := BalancerOptions{Policy: LBPolicy{LoadBalancer: lbName, HashKey: HashKeyByMethod}}
+		lb, err := NewPolarisBalancerWithOptions(bo, WithPolarisClient(testClient))
+		require.Nil(t, err)
+
+		// the calls of Next within an attempt
+		picker := lb.GetPicker(result)
+		ctx := newRPCInfoCtx(svcName, "Echo")
+		picked := map[string]bool{}
+		for i := 0; i < 3; i++ {
+			ins := picker.Next(ctx, nil)
+			require.NotNil(t, ins, lbName)
+			picked[ins.Address().String()] = true
+		}
+		require.Len(t, picked, 3, lbName)
+		// falls back to all the instances
+		require.NotNil(t, picker.Next(ctx, nil), lbName)
+
+		// the attempts of a retried RPC share the instances tried through the ctx
+		ctx = RetryTracer{}.Start(newRPCInfoCtx(svcName, "Echo"))
+		picked = map[string]bool{}
+		for i := 0; i < 3; i++ {
+			ins := lb.GetPicker(result).Next(ctx, nil)
+			require.NotNil(t, ins, lbName)
+			picked[ins.Address().String()] = true
+		}
+		require.Len(t, picked, 3, lbName)
+		lb.Destroy()
+	}
+
+	bo := BalancerOptions{ExcludedFallback: ExcludedFallbackFail}
+	lb, err := NewPolarisBalancerWithOptions(bo, WithPolarisClient(testClient))
+	require.Nil(t, err)
+	defer lb.Destroy()
+	ctx := RetryTracer{}.Start(newRPCInfoCtx(svcName, "Echo"))
+	for i := 0; i < 3; i++ {
+		require.NotNil(t, lb.GetPicker(result).Next(ctx, nil))
+	}
+	pick := func(ctx context.Context, req, resp interface{}) error {
+		if lb.GetPicker(result).Next(ctx, req) == nil {
+			return kerrors.ErrNoMoreInstance.WithCause(errors.New("last error: <nil>"))
+		}
+		return nil
+	}
+	err = NewUpdateServiceCallResultMW(WithPolarisClient(testClient))(pick)(ctx, nil, nil)
+	require.True(t, errors.Is(err, ErrAllInstancesTried), err)
+}
+
+func TestPolarisPickerSrcLabels(t *testing.T) {
+	svcName := "balancer-src-labels"
+	testServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{
+		Host: "127.0.0.1", Port: 9051, Metadata: map[string]string{"env": "base"},
+	})
+	testServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{
+		Host: "127.0.0.1", Port: 9052, Metadata: map[string]string{"env": "gray"},
+	})
+	route := func(srcMetadata map[string]string, env string) *namingpb.Route {
+		source := &namingpb.Source{
+			Namespace: &wrappers.StringValue{Value: "*"},
+			Service:   &wrappers.StringValue{Value: "*"},
+			Metadata:  map[string]*namingpb.MatchString{},
+		}
+		for k, v := range srcMetadata {
+			source.Metadata[k] = &namingpb.MatchString{Value: &wrappers.StringValue{Value: v}}
+		}
+		return &namingpb.Route{
+			Sources: []*namingpb.Source{source},
+			Destinations: []*namingpb.Destination{{
+				Namespace: &wrappers.StringValue{Value: DefaultPolarisNamespace},
+				Service:   &wrappers.StringValue{Value: svcName},
+				Metadata: map[string]*namingpb.MatchString{
+					"env": {Value: &wrappers.StringValue{Value: env}},
+				},
+				Weight: &wrappers.UInt32Value{Value: 100},
+			}},
+		}
+	}
+	testServer.SetRouting(DefaultPolarisNamespace, svcName, &namingpb.Routing{
+		Inbounds: []*namingpb.Route{
+			route(map[string]string{"tenant": "gray", "app": "demo"}, "gray"),
+			route(nil, "base"),
+		},
+	})
+
+	o := ClientOptions{
+		SrcMetadata: map[string]string{"app": "demo"},
+		SrcLabels:   SrcLabels{MetainfoKeys: []string{"tenant"}},
+	}
+	rs, err := NewPolarisResolver(o, WithPolarisClient(testClient))
+	require.Nil(t, err)
+	lb, err := NewPolarisBalancer(WithPolarisClient(testClient))
+	require.Nil(t, err)
+	defer lb.Destroy()
+	result := resolveForBalancer(t, rs, svcName)
+
+	for i := 0; i < 10; i++ {
+		ins := lb.GetPicker(result).Next(newRPCInfoCtx(svcName, "Echo"), nil)
+		require.NotNil(t, ins)
+		require.Equal(t, "127.0.0.1:9051", ins.Address().String())
+
+		ctx := metainfo.WithValue(newRPCInfoCtx(svcName, "Echo"), "tenant", "gray")
+		ins = lb.GetPicker(result).Next(ctx, nil)
+		require.NotNil(t, ins)
+		require.Equal(t, "127.0.0.1:9052", ins.Address().String())
+	}
+	require.Equal(t, map[string]string{"app": "demo"}, o.SrcMetadata)
+}
+
+func TestSrcLabels(t *testing.T) {
+	static := map[string]string{"app": "demo", "tenant": "static", "canary": "0"}
+	require.Equal(t, static, SrcLabels{}.srcMetadata(context.Background(), static))
+
+	to := rpcinfo.NewEndpointInfo("svc", "Echo", nil, map[string]string{"canary": "1"})
+	ri := rpcinfo.NewRPCInfo(nil, to, rpcinfo.NewInvocation("svc", "Echo"), nil, nil)
+	ctx := rpcinfo.NewCtxWithRPCInfo(context.Background(), ri)
+	ctx = metainfo.WithValue(ctx, "tenant", "transient")
+	ctx = metainfo.WithPersistentValue(ctx, "user", "persistent")
+	sl := SrcLabels{
+		MetainfoKeys: []string{"tenant", "user", "missing"},
+		TagKeys:      []string{"canary"},
+		Extractor: func(ctx context.Context) map[string]string {
+			return map[string]string{"region": "sh"}
+		},
+	}
+	require.Equal(t, map[string]string{
+		"app": "demo", "tenant": "transient", "user": "persistent", "canary": "1", "region": "sh",
+	}, sl.srcMetadata(ctx, static))
+	require.Equal(t, "static", static["tenant"])
+}
+
+func routeToEnv(svcName, env string) *namingpb.Routing {
+	return &namingpb.Routing{
+		Inbounds: []*namingpb.Route{{
+			Sources: []*namingpb.Source{{
+				Namespace: &wrappers.StringValue{Value: "*"},
+				Service:   &wrappers.StringValue{Value: "*"},
+			}},
+			Destinations: []*namingpb.Destination{{
+				Namespace: &wrappers.StringValue{Value: DefaultPolarisNamespace},
+				Service:   &wrappers.StringValue{Value: svcName},
+				Metadata: map[string]*namingpb.MatchString{
+					"env": {Value: &wrappers.StringValue{Value: env}},
+				},
+				Weight: &wrappers.UInt32Value{Value: 100},
+			}},
+		}},
+	}
+}
+
+func routeCacheSize(picker interface{}) int {
+	size := 0
+	picker.(*polarisPicker).info.routes.entries.Range(func(key, value interface{}) bool {
+		size++
+		return true
+	})
+	return size
+}
+
+func TestPolarisPickerRouteCache(t *testing.T) {
+	svcName := "balancer-route-cache"
+	testServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{
+		Host: "127.0.0.1", Port: 9091, Metadata: map[string]string{"env": "base"},
+	})
+	testServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{
+		Host: "127.0.0.1", Port: 9092, Metadata: map[string]string{"env": "gray"},
+	})
+	testServer.SetRouting(DefaultPolarisNamespace, svcName, routeToEnv(svcName, "base"))
+
+	o := ClientOptions{SrcLabels: SrcLabels{MetainfoKeys: []string{"tenant"}}}
+	rs, err := NewPolarisResolver(o, WithPolarisClient(testClient))
+	require.Nil(t, err)
+	bo := BalancerOptions{RouteCache: RouteCacheOptions{TTL: time.Hour, MaxEntries: 2}}
+	lb, err := NewPolarisBalancerWithOptions(bo, WithPolarisClient(testClient))
+	require.Nil(t, err)
+	defer lb.Destroy()
+	result := resolveForBalancer(t, rs, svcName)
+
+	pick := func(tenant string) string {
+		ctx := newRPCInfoCtx(svcName, "Echo")
+		if tenant != "" {
+			ctx = metainfo.WithValue(ctx, "tenant", tenant)
+		}
+		ins := lb.GetPicker(result).Next(ctx, nil)
+		require.NotNil(t, ins)
+		return ins.Address().String()
+	}
+	for i := 0; i < 10; i++ {
+		require.Equal(t, "127.0.0.1:9091", pick(""))
+		require.Equal(t, "127.0.0.1:9091", pick("a"))
+		require.Equal(t, "127.0.0.1:9091", pick("b"))
+	}
+	// the routes of tenant b are not cached beyond MaxEntries
+	require.Equal(t, 2, routeCacheSize(lb.GetPicker(result)))
+	// the pickers of a cached route share its instances, and the caches of the SDK in them
+	routed := func() interface{} {
+		picker := lb.GetPicker(result).(*polarisPicker)
+		require.NotNil(t, picker.Next(newRPCInfoCtx(svcName, "Echo"), nil))
+		return picker.routerInstancesResp
+	}
+	require.Same(t, routed(), routed())
+
+	// the cache is invalidated by the change of the routing rules
+	testServer.SetRouting(DefaultPolarisNamespace, svcName, routeToEnv(svcName, "gray"))
+	require.Eventually(t, func() bool {
+		return pick("") == "127.0.0.1:9092" && pick("a") == "127.0.0.1:9092"
+	}, polaristest.SyncTimeout, polaristest.RefreshInterval)
+
+	// and by the change of the instances
+	lb.(loadbalance.Rebalancer).Rebalance(discovery.Change{Result: result})
+	require.Equal(t, 0, routeCacheSize(lb.GetPicker(result)))
+}
+
+func TestRouteCacheEviction(t *testing.T) {
+	var c routeCache
+	now := time.Now()
+	ttl := time.Second
+	put := func(key string, at time.Time) {
+		c.put(key, &routeEntry{ruleRevision: "r", expire: at.Add(ttl).UnixNano()}, 2, at)
+	}
+	cached := func(key string, at time.Time) bool {
+		_, ok := c.get(key, "r", at)
+		return ok
+	}
+	put("a", now)
+	put("b", now)
+	put("c", now)
+	require.True(t, cached("a", now))
+	require.True(t, cached("b", now))
+	require.False(t, cached("c", now))
+
+	// the expired entries make room for the new keys
+	later := now.Add(ttl)
+	put("c", later)
+	put("d", later)
+	require.True(t, cached("c", later))
+	require.True(t, cached("d", later))
+	require.False(t, cached("a", later))
+	require.Equal(t, int32(2), c.size)
+
+	// the cache stays full until c and d expire
+	put("e", later.Add(ttl/2))
+	require.False(t, cached("e", later.Add(ttl/2)))
+	put("e", later.Add(ttl))
+	require.True(t, cached("e", later.Add(ttl)))
+}
+
+func TestPolarisPickerConcurrent(t *testing.T) {
+	svcName := "balancer-concurrent"
+	for i := 0; i < 4; i++ {
+		testServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{
+			Host: "127.0.0.1", Port: 9101 + i, Weight: 100 * (i + 1),
+		})
+	}
+	rs, err := NewPolarisResolver(ClientOptions{}, WithPolarisClient(testClient))
+	require.Nil(t, err)
+	result := resolveForBalancer(t, rs, svcName)
+	require.Len(t, result.Instances, 4)
+
+	for _, policy := range []string{LBWeightedRandom, LBWeightedRoundRobin, LBRingHash, LBMaglev, LBHash} {
+		t.Run(policy, func(t *testing.T) {
+			bo := BalancerOptions{Policy: LBPolicy{LoadBalancer: policy, HashKey: HashKeyByMetainfo("session")}}
+			lb, err := NewPolarisBalancerWithOptions(bo, WithPolarisClient(testClient))
+			require.Nil(t, err)
+			defer lb.Destroy()
+
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					ctx := metainfo.WithValue(newRPCInfoCtx(svcName, "Echo"), "session", string(rune('a'+i)))
+					for j := 0; j < 50; j++ {
+						picker := lb.GetPicker(result)
+						if picker.Next(ctx, nil) == nil {
+							t.Error("no instance picked")
+						}
+						picker.(interface{ Recycle() }).Recycle()
+					}
+				}(i)
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func TestRouteKey(t *testing.T) {
+	require.Equal(t, "Echo", routeKey("Echo", nil))
+	require.Equal(t, routeKey("Echo", map[string]string{"a": "1", "b": "2"}), routeKey("Echo", map[string]string{"b": "2", "a": "1"}))
+	require.NotEqual(t, routeKey("Echo", map[string]string{"a": "1"}), routeKey("Echo", map[string]string{"a": "2"}))
+	require.NotEqual(t, routeKey("Echo", map[string]string{"a": "1"}), routeKey("Ping", map[string]string{"a": "1"}))
+}
+
+var benchmarkOnce sync.Once
+
+// benchmarkBalancer picks among the 1000 instances of a service by policy, with or without the route cache.
+func benchmarkBalancer(b *testing.B, policy LBPolicy, disableCache bool) (Balancer, discovery.Result, string) {
+	svcName := "balancer-bench"
+	benchmarkOnce.Do(func() {
+		for i := 0; i < 1000; i++ {
+			testServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{
+				Host: "10.0.0.1", Port: 10000 + i, Metadata: map[string]string{"env": "base"},
+			})
+		}
+		testServer.SetRouting(DefaultPolarisNamespace, svcName, routeToEnv(svcName, "base"))
+	})
+	rs, err := NewPolarisResolver(ClientOptions{}, WithPolarisClient(testClient))
+	if err != nil {
+		b.Fatal(err)
+	}
+	desc := rs.Target(context.TODO(), rpcinfo.NewEndpointInfo(svcName, "", nil, nil))
+	result, err := rs.Resolve(context.TODO(), desc)
+	if err != nil {
+		b.Fatal(err)
+	}
+	result.CacheKey = rs.Name() + ":" + result.CacheKey
+	bo := BalancerOptions{Policy: policy, RouteCache: RouteCacheOptions{Disable: disableCache}}
+	lb, err := NewPolarisBalancerWithOptions(bo, WithPolarisClient(testClient))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return lb, result, svcName
+}
+
+func BenchmarkPolarisBalancerGetPicker(b *testing.B) {
+	lb, result, _ := benchmarkBalancer(b, LBPolicy{}, false)
+	defer lb.Destroy()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lb.GetPicker(result).(interface{ Recycle() }).Recycle()
+	}
+}
+
+func benchmarkPickerNext(b *testing.B, policy LBPolicy) {
+	for _, bc := range []struct {
+		name         string
+		disableCache bool
+	}{{"RouteCache", false}, {"NoRouteCache", true}} {
+		b.Run(bc.name, func(b *testing.B) {
+			lb, result, svcName := benchmarkBalancer(b, policy, bc.disableCache)
+			defer lb.Destroy()
+			ctx := metainfo.WithValue(newRPCInfoCtx(svcName, "Echo"), "session", "bench")
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				picker := lb.GetPicker(result)
+				if picker.Next(ctx, nil) == nil {
+					b.Fatal("no instance picked")
+				}
+				picker.(interface{ Recycle() }).Recycle()
+			}
+		})
+	}
+}
+
+func BenchmarkPolarisPickerNext(b *testing.B) {
+	benchmarkPickerNext(b, LBPolicy{})
+}
+
+func BenchmarkPolarisPickerNextHash(b *testing.B) {
+	for _, lb := range []string{LBRingHash, LBMaglev} {
+		b.Run(lb, func(b *testing.B) {
+			benchmarkPickerNext(b, LBPolicy{LoadBalancer: lb, HashKey: HashKeyByMetainfo("session")})
+		})
+	}
+}
@@ -18,6 +18,7 @@ package polaris
 
 import (
 	"log"
+	"time"
 
 	"github.com/cloudwego/kitex/client"
 	"github.com/cloudwego/kitex/pkg/discovery"
@@ -30,6 +31,19 @@ type ClientOptions struct {
 	SrcNamespace string            `json:"src_namespace"`
 	SrcService   string            `json:"src_service"`
 	SrcMetadata  map[string]string `json:"src_metadata"`
+	// Nearby keeps the traffic on the instances closest to the client.
+	Nearby NearbyOptions `json:"nearby"`
+	// Lane keeps the requests of a lane on the instances of the lane.
+	Lane LaneOptions `json:"lane"`
+	// SrcLabels adds labels of each request to SrcMetadata for the routing rules.
+	SrcLabels SrcLabels `json:"src_labels"`
+	// ServeStaleOnError makes the resolver return the last known instances while polaris is unavailable.
+	ServeStaleOnError bool `json:"serve_stale_on_error"`
+	// StaleMaxAge is the longest time the last known instances are served, zero means no limit.
+	StaleMaxAge time.Duration `json:"stale_max_age"`
+	// MetadataTagPrefix is prepended to the metadata keys of the polaris instances to get them by the Tag method
+	// of the discovered instances, e.g. "meta." keeps them apart from the attribute tags such as VersionTagKey.
+	MetadataTagPrefix string `json:"metadata_tag_prefix"`
 }
 
 // ClientSuite It is used to assemble multiple associated client's Options
@@ -37,7 +51,13 @@ type ClientSuite struct {
 	DstNameSpace       string                   // dest namespace for service discovery
 	Resolver           discovery.Resolver       // service discovery component
 	Balancer           loadbalance.Loadbalancer // load balancer
+	BalancerOptions    BalancerOptions          // load balancing policies of the default balancer
+	ClientOptions      ClientOptions            // options of the default resolver
 	ReportCallResultMW endpoint.Middleware      // report service call result for circuitbreak
+	PolarisOptions     []Option                 // options to create the default components with
+	// ExcludeTriedOnRetry adds RetryTracer, so the retries of an RPC skip the instances it already tried.
+	// Kitex records the detailed stats of the RPCs once a tracer is added, unless client.WithStatsLevel says otherwise.
+	ExcludeTriedOnRetry bool
 }
 
 func NewDefaultClientSuite() *ClientSuite {
@@ -57,12 +77,16 @@ func (cs *ClientSuite) Options() []client.Option {
 	if cs.Resolver != nil {
 		resolver = cs.Resolver
 	} else {
-		o := ClientOptions{}
-		r, err := NewPolarisResolver(o)
+		r, err := NewPolarisResolver(cs.ClientOptions, cs.PolarisOptions...)
 		if err != nil {
 			log.Fatal(err)
 		}
 		resolver = r
+		// stops the subscriptions of the resolver when the Kitex client is closed
+		opts = append(opts, client.WithCloseCallbacks(func() error {
+			r.Destroy()
+			return nil
+		}))
 	}
 	opts = append(opts, client.WithResolver(resolver))
 
@@ -70,18 +94,36 @@ func (cs *ClientSuite) Options() []client.Option {
 	if cs.Balancer != nil {
 		lb = cs.Balancer
 	} else {
-		pb, err := NewPolarisBalancer()
+		pb, err := NewPolarisBalancerWithOptions(cs.BalancerOptions, cs.PolarisOptions...)
 		if err != nil {
 			log.Fatal(err)
 		}
 		lb = pb
+		opts = append(opts, client.WithCloseCallbacks(func() error {
+			pb.Destroy()
+			return nil
+		}))
 	}
 	opts = append(opts, client.WithLoadBalancer(lb))
+	if cs.ExcludeTriedOnRetry {
+		// shares the instances tried by an RPC with its retries
+		opts = append(opts, client.WithTracer(RetryTracer{}))
+	}
 
 	if cs.ReportCallResultMW != nil {
 		opts = append(opts, client.WithMiddleware(cs.ReportCallResultMW))
 	} else {
-		opts = append(opts, client.WithMiddleware(NewUpdateServiceCallResultMW()))
+		ref, err := newClientRef(cs.PolarisOptions)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, client.WithMiddleware(func(next endpoint.Endpoint) endpoint.Endpoint {
+			return callResultEndpoint(ref, next)
+		}))
+		opts = append(opts, client.WithCloseCallbacks(func() error {
+			ref.Destroy()
+			return nil
+		}))
 	}
 
 	return opts
@@ -25,13 +25,12 @@ import (
 
 	"github.com/cloudwego/kitex/pkg/discovery"
 	"github.com/polarismesh/polaris-go/api"
-	"github.com/polarismesh/polaris-go/pkg/config"
 	"github.com/polarismesh/polaris-go/pkg/model"
 )
 
 var (
-	polarisContext      api.SDKContext
-	mutexPolarisContext sync.Mutex
+	sharedClients      = make(map[string]*PolarisClient)
+	mutexSharedClients sync.Mutex
 )
 
 const (
@@ -41,34 +40,39 @@ const (
 )
 
 // GetPolarisConfig get polaris config from endpoints.
+// Contexts are shared per configFile, the default polaris.yaml is used when configFile is empty.
 func GetPolarisConfig(configFile ...string) (api.SDKContext, error) {
-	mutexPolarisContext.Lock()
-	defer mutexPolarisContext.Unlock()
-	if nil != polarisContext {
-		return polarisContext, nil
+	var file string
+	if len(configFile) != 0 {
+		file = configFile[0]
 	}
+	client, err := getSharedClient(&options{configFile: file})
+	if err != nil {
+		return nil, err
+	}
+	return client.SDKContext(), nil
+}
 
-	var (
-		cfg config.Configuration
-		err error
-	)
-
-	if len(configFile) != 0 {
-		cfg, err = config.LoadConfigurationByFile(configFile[0])
-	} else {
-		cfg, err = config.LoadConfigurationByDefaultFile()
+// getSharedClient returns the process wide client of the options, which is never destroyed.
+// Components configured with the same file and configuration options share a client.
+func getSharedClient(o *options) (*PolarisClient, error) {
+	key := o.sharedKey()
+	mutexSharedClients.Lock()
+	defer mutexSharedClients.Unlock()
+	if client, ok := sharedClients[key]; ok {
+		return client, nil
 	}
 
+	cfg, err := o.buildConfig()
 	if err != nil {
 		return nil, err
 	}
-
-	polarisContext, err = api.InitContextByConfig(cfg)
+	client, err := NewPolarisClientByConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
-
-	return polarisContext, nil
+	sharedClients[key] = client
+	return client, nil
 }
 
 // SplitDescription splits description to namespace and serviceName.
@@ -86,11 +90,9 @@ func SplitCachedKey(cachedKey string) (string, string) {
 }
 
 // ChangePolarisInstanceToKitex transforms polaris instance to Kitex instance.
+// The weight is kept as it is, the Kitex balancers skip the instances of weight 0 such as the drained ones.
 func ChangePolarisInstanceToKitex(PolarisInstance model.Instance, polarisOptions ClientOptions) *polarisKitexInstance {
 	weight := PolarisInstance.GetWeight()
-	if weight <= 0 {
-		weight = DefaultWeight
-	}
 	addr := PolarisInstance.GetHost() + ":" + strconv.Itoa(int(PolarisInstance.GetPort()))
 
 	tags := map[string]string{
@@ -107,7 +109,7 @@ func ChangePolarisInstanceToKitex(PolarisInstance model.Instance, polarisOptions
 	return kitexInstance
 }
 
-// GetLocalIPv4Address gets local ipv4 address when info host is empty.
+// GetLocalIPv4Address gets the first non loopback local ipv4 address, GetLocalAddress selects it more precisely.
 func GetLocalIPv4Address() (string, error) {
 	addr, err := net.InterfaceAddrs()
 	if err != nil {
@@ -127,7 +129,8 @@ func GetLocalIPv4Address() (string, error) {
 }
 
 // GetInfoHostAndPort gets Host and port from info.Addr.
-func GetInfoHostAndPort(Addr string) (string, int, error) {
+// The local address selected by the AddressOptions, or by the default ones, is used when the host is empty or unspecified.
+func GetInfoHostAndPort(Addr string, ao ...AddressOptions) (string, int, error) {
 	infoHost, port, err := net.SplitHostPort(Addr)
 	if err != nil {
 		return "", 0, err
@@ -135,12 +138,16 @@ func GetInfoHostAndPort(Addr string) (string, int, error) {
 		if port == "" {
 			return infoHost, 0, fmt.Errorf("registry info addr missing port")
 		}
-		if infoHost == "" {
-			ipv4, err := GetLocalIPv4Address()
+		if ip := net.ParseIP(infoHost); infoHost == "" || (ip != nil && ip.IsUnspecified()) {
+			var opts AddressOptions
+			if len(ao) != 0 {
+				opts = ao[0]
+			}
+			localHost, err := GetLocalAddress(opts)
 			if err != nil {
-				return "", 0, fmt.Errorf("get local ipv4 error, cause %v", err)
+				return "", 0, fmt.Errorf("get local address error, cause %v", err)
 			}
-			infoHost = ipv4
+			infoHost = localHost
 		}
 	}
 	infoPort, err := strconv.Atoi(port)
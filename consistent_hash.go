@@ -0,0 +1,226 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/bytedance/gopkg/lang/fastrand"
+	"github.com/bytedance/gopkg/util/xxhash3"
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/loadbalance"
+)
+
+const defaultReplicateCount = 100
+
+// ConsistentHashOptions configures the balancer created by NewConsistentHashBalancer.
+type ConsistentHashOptions struct {
+	// Key returns the key of the requests, the requests without key are balanced by weighted random.
+	Key HashKeyFunc
+	// ReplicateCount is the number of virtual nodes of an instance of weight 100, 100 if zero,
+	// the instances of other weights have proportionally as many.
+	ReplicateCount int
+}
+
+// consistentHashBalancer picks the instances on a weighted consistent hash ring, without calling polaris,
+// so the picks go on while polaris is unavailable.
+type consistentHashBalancer struct {
+	opts  ConsistentHashOptions
+	rings sync.Map // cache key => *hashRing
+}
+
+// NewConsistentHashBalancer creates a balancer picking the instances on a weighted consistent hash ring
+// of the discovered instances, which is updated incrementally on the changes of the instances.
+func NewConsistentHashBalancer(opts ConsistentHashOptions) loadbalance.Loadbalancer {
+	if opts.ReplicateCount <= 0 {
+		opts.ReplicateCount = defaultReplicateCount
+	}
+	return &consistentHashBalancer{opts: opts}
+}
+
+// GetPicker implements the loadbalance.Loadbalancer interface.
+func (b *consistentHashBalancer) GetPicker(e discovery.Result) loadbalance.Picker {
+	var ring *hashRing
+	if e.Cacheable {
+		if cached, ok := b.rings.Load(e.CacheKey); ok {
+			ring = cached.(*hashRing)
+		} else {
+			ring = newHashRing(b.opts.ReplicateCount, e.Instances)
+			b.rings.Store(e.CacheKey, ring)
+		}
+	} else {
+		ring = newHashRing(b.opts.ReplicateCount, e.Instances)
+	}
+	return &consistentHashPicker{ring: ring, key: b.opts.Key}
+}
+
+// Name implements the loadbalance.Loadbalancer interface.
+func (b *consistentHashBalancer) Name() string {
+	return "polaris_consistent_hash"
+}
+
+// Rebalance implements the loadbalance.Rebalancer interface.
+func (b *consistentHashBalancer) Rebalance(change discovery.Change) {
+	if !change.Result.Cacheable {
+		return
+	}
+	cached, ok := b.rings.Load(change.Result.CacheKey)
+	if !ok {
+		b.rings.Store(change.Result.CacheKey, newHashRing(b.opts.ReplicateCount, change.Result.Instances))
+		return
+	}
+	b.rings.Store(change.Result.CacheKey, cached.(*hashRing).update(change))
+}
+
+// Delete implements the loadbalance.Rebalancer interface.
+func (b *consistentHashBalancer) Delete(change discovery.Change) {
+	if !change.Result.Cacheable {
+		return
+	}
+	b.rings.Delete(change.Result.CacheKey)
+}
+
+// hashRing is an immutable consistent hash ring.
+type hashRing struct {
+	replicateCount int
+	nodes          []hashNode // sorted by hash
+	instances      int
+}
+
+type hashNode struct {
+	hash uint64
+	key  string
+	ins  discovery.Instance
+}
+
+func newHashRing(replicateCount int, instances []discovery.Instance) *hashRing {
+	r := &hashRing{replicateCount: replicateCount}
+	for _, ins := range instances {
+		r.nodes = r.appendNodes(r.nodes, ins)
+	}
+	r.instances = len(instances)
+	sort.Slice(r.nodes, func(i, j int) bool { return r.nodes[i].hash < r.nodes[j].hash })
+	return r
+}
+
+// hashInstanceKey identifies the instances, by their polaris id if they have one.
+func hashInstanceKey(ins discovery.Instance) string {
+	if pkIns, ok := ins.(*polarisKitexInstance); ok && pkIns.polarisInstance.GetId() != "" {
+		return pkIns.polarisInstance.GetId()
+	}
+	return ins.Address().String()
+}
+
+// appendNodes appends the virtual nodes of ins, in proportion to its weight.
+func (r *hashRing) appendNodes(nodes []hashNode, ins discovery.Instance) []hashNode {
+	if ins.Weight() <= 0 {
+		return nodes
+	}
+	count := r.replicateCount * ins.Weight() / 100
+	if count < 1 {
+		count = 1
+	}
+	key := hashInstanceKey(ins)
+	for i := 0; i < count; i++ {
+		nodes = append(nodes, hashNode{hash: xxhash3.HashString(key + "#" + strconv.Itoa(i)), key: key, ins: ins})
+	}
+	return nodes
+}
+
+// update returns a ring with the instances removed, added or updated by change,
+// the nodes of the other instances are kept as they are.
+func (r *hashRing) update(change discovery.Change) *hashRing {
+	stale := make(map[string]struct{}, len(change.Removed)+len(change.Updated))
+	for _, ins := range change.Removed {
+		stale[hashInstanceKey(ins)] = struct{}{}
+	}
+	for _, ins := range change.Updated {
+		stale[hashInstanceKey(ins)] = struct{}{}
+	}
+	var added []hashNode
+	for _, ins := range change.Added {
+		added = r.appendNodes(added, ins)
+	}
+	for _, ins := range change.Updated {
+		added = r.appendNodes(added, ins)
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i].hash < added[j].hash })
+
+	// merges the kept nodes and the added ones, which are both sorted
+	nodes := make([]hashNode, 0, len(r.nodes)+len(added))
+	i := 0
+	for _, node := range r.nodes {
+		if _, ok := stale[node.key]; ok {
+			continue
+		}
+		for ; i < len(added) && added[i].hash < node.hash; i++ {
+			nodes = append(nodes, added[i])
+		}
+		nodes = append(nodes, node)
+	}
+	nodes = append(nodes, added[i:]...)
+	return &hashRing{replicateCount: r.replicateCount, nodes: nodes, instances: len(change.Result.Instances)}
+}
+
+// search returns the index of the first node clockwise from hash.
+func (r *hashRing) search(hash uint64) int {
+	i := sort.Search(len(r.nodes), func(i int) bool { return r.nodes[i].hash >= hash })
+	if i == len(r.nodes) {
+		return 0
+	}
+	return i
+}
+
+// consistentHashPicker picks the instance of the key of the request,
+// then the next distinct instances clockwise when Kitex asks for another one.
+type consistentHashPicker struct {
+	ring  *hashRing
+	key   HashKeyFunc
+	index int
+	tried []string
+}
+
+// Next implements the loadbalance.Picker interface.
+func (p *consistentHashPicker) Next(ctx context.Context, request interface{}) discovery.Instance {
+	nodes := p.ring.nodes
+	if len(nodes) == 0 || len(p.tried) >= p.ring.instances {
+		return nil
+	}
+	if p.tried == nil {
+		var key []byte
+		if p.key != nil {
+			key = p.key(ctx, request)
+		}
+		if len(key) != 0 {
+			p.index = p.ring.search(xxhash3.Hash(key))
+		} else {
+			p.index = fastrand.Intn(len(nodes))
+		}
+	}
+	for n := 0; n < len(nodes); n++ {
+		node := nodes[(p.index+n)%len(nodes)]
+		if !containsString(p.tried, node.key) {
+			p.index = (p.index + n) % len(nodes)
+			p.tried = append(p.tried, node.key)
+			return node.ins
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kitex-contrib/polaris/polaristest"
+)
+
+func hashKeyOf(ctx context.Context, request interface{}) []byte {
+	key, _ := request.(string)
+	return []byte(key)
+}
+
+func ringKeys(r *hashRing) []string {
+	var keys []string
+	for _, node := range r.nodes {
+		keys = append(keys, fmt.Sprintf("%d-%s", node.hash, node.key))
+	}
+	return keys
+}
+
+func TestConsistentHashBalancer(t *testing.T) {
+	var instances []discovery.Instance
+	for i := 0; i < 5; i++ {
+		instances = append(instances, discovery.NewInstance("tcp", fmt.Sprintf("127.0.0.1:%d", 9100+i), 100, nil))
+	}
+	result := discovery.Result{Cacheable: true, CacheKey: "polaris:consistent", Instances: instances}
+	lb := NewConsistentHashBalancer(ConsistentHashOptions{Key: hashKeyOf})
+	rb := lb.(interface {
+		Rebalance(discovery.Change)
+	})
+
+	picked := map[string]string{}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprint("key-", i)
+		ins := lb.GetPicker(result).Next(context.Background(), key)
+		require.NotNil(t, ins)
+		require.Equal(t, ins, lb.GetPicker(result).Next(context.Background(), key))
+		picked[key] = ins.Address().String()
+	}
+
+	// the keys of the other instances stay on them when an instance is removed
+	removed := instances[2]
+	next := discovery.Result{Cacheable: true, CacheKey: result.CacheKey}
+	next.Instances = append(append(next.Instances, instances[:2]...), instances[3:]...)
+	rb.Rebalance(discovery.Change{Result: next, Removed: []discovery.Instance{removed}})
+	for key, addr := range picked {
+		ins := lb.GetPicker(next).Next(context.Background(), key)
+		require.NotEqual(t, removed.Address().String(), ins.Address().String())
+		if addr != removed.Address().String() {
+			require.Equal(t, addr, ins.Address().String())
+		}
+	}
+
+	// the ring updated incrementally is the one built from scratch
+	added := discovery.NewInstance("tcp", "127.0.0.1:9200", 300, nil)
+	updated := discovery.NewInstance("tcp", instances[0].Address().String(), 50, nil)
+	final := discovery.Result{Cacheable: true, CacheKey: result.CacheKey}
+	final.Instances = append(final.Instances, updated, instances[1], instances[3], instances[4], added)
+	rb.Rebalance(discovery.Change{
+		Result: final, Added: []discovery.Instance{added}, Updated: []discovery.Instance{updated},
+	})
+	ring := lb.GetPicker(final).(*consistentHashPicker).ring
+	require.Equal(t, ringKeys(newHashRing(defaultReplicateCount, final.Instances)), ringKeys(ring))
+
+	// the picks are weighted
+	counts := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		counts[lb.GetPicker(final).Next(context.Background(), fmt.Sprint("key-", i)).Address().String()]++
+	}
+	require.Greater(t, counts[added.Address().String()], 2*counts[instances[1].Address().String()])
+	require.Less(t, counts[updated.Address().String()], counts[instances[1].Address().String()])
+
+	// the next picks are the other instances, then none
+	picker := lb.GetPicker(final)
+	addrs := map[string]bool{}
+	for i := 0; i < len(final.Instances); i++ {
+		ins := picker.Next(context.Background(), "key")
+		require.NotNil(t, ins)
+		addrs[ins.Address().String()] = true
+	}
+	require.Len(t, addrs, len(final.Instances))
+	require.Nil(t, picker.Next(context.Background(), "key"))
+
+	// the requests without key are balanced
+	addrs = map[string]bool{}
+	for i := 0; i < 100; i++ {
+		addrs[lb.GetPicker(final).Next(context.Background(), nil).Address().String()] = true
+	}
+	require.Greater(t, len(addrs), 1)
+}
+
+func TestConsistentHashBalancerPolarisInstances(t *testing.T) {
+	svcName := "consistent-hash"
+	for port := 9081; port <= 9083; port++ {
+		testServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{Host: "127.0.0.1", Port: port})
+	}
+	rs, err := NewPolarisResolver(ClientOptions{}, WithPolarisClient(testClient))
+	require.Nil(t, err)
+	result := resolveForBalancer(t, rs, svcName)
+
+	lb := NewConsistentHashBalancer(ConsistentHashOptions{Key: HashKeyByMethod})
+	ins := lb.GetPicker(result).Next(newRPCInfoCtx(svcName, "Echo"), nil)
+	require.NotNil(t, ins)
+	for i := 0; i < 10; i++ {
+		require.Equal(t, ins, lb.GetPicker(result).Next(newRPCInfoCtx(svcName, "Echo"), nil))
+	}
+}
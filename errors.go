@@ -0,0 +1,109 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/polarismesh/polaris-go/pkg/model"
+	namingpb "github.com/polarismesh/polaris-go/pkg/model/pb/v1"
+)
+
+var (
+	// ErrServiceNotFound means the service does not exist in polaris.
+	ErrServiceNotFound = errors.New("service not found")
+	// ErrControlPlaneUnavailable means polaris can not be reached or failed to serve the request.
+	ErrControlPlaneUnavailable = errors.New("control plane unavailable")
+	// ErrInstanceNotFound means polaris doesn't know the instance, e.g. it expired or the server restarted.
+	ErrInstanceNotFound = errors.New("instance not found")
+	// ErrNoRoutedInstance means the routing rules of polaris left no instance to pick.
+	ErrNoRoutedInstance = errors.New("no instance matches the routing rules")
+	// ErrAllInstancesTried means every routed instance has been tried by the RPC, see ExcludedFallbackFail.
+	ErrAllInstancesTried = errors.New("all the instances have been tried")
+)
+
+// PolarisError wraps an error returned by the polaris SDK, use errors.Is with
+// ErrServiceNotFound, ErrControlPlaneUnavailable, ErrInstanceNotFound or ErrNoRoutedInstance to check its kind.
+type PolarisError struct {
+	// Op is the operation which failed, such as GetInstances.
+	Op string
+	// Desc is the description of the service, made of namespace and service name.
+	Desc string
+	// Kind is ErrServiceNotFound, ErrControlPlaneUnavailable, ErrInstanceNotFound, ErrNoRoutedInstance or nil if unknown.
+	Kind error
+	// Err is the error returned by the polaris SDK.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *PolarisError) Error() string {
+	if e.Kind != nil {
+		return fmt.Sprintf("polaris %s %s: %v: %v", e.Op, e.Desc, e.Kind, e.Err)
+	}
+	return fmt.Sprintf("polaris %s %s: %v", e.Op, e.Desc, e.Err)
+}
+
+// Unwrap returns the error returned by the polaris SDK.
+func (e *PolarisError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the error is of the target kind.
+func (e *PolarisError) Is(target error) bool {
+	return e.Kind != nil && e.Kind == target
+}
+
+// newPolarisError classifies err returned by the polaris SDK for op on desc.
+func newPolarisError(op, desc string, err error) *PolarisError {
+	return &PolarisError{Op: op, Desc: desc, Kind: errorKind(op, err), Err: err}
+}
+
+// instanceOps are the operations on an instance, the resources they don't find are the instance itself.
+var instanceOps = map[string]bool{"Heartbeat": true, "Deregister": true}
+
+func errorKind(op string, err error) error {
+	sdkErr, ok := err.(model.SDKError)
+	if !ok {
+		return nil
+	}
+	switch sdkErr.ServerCode() {
+	case namingpb.NotFoundResource, namingpb.NotFoundInstance:
+		if instanceOps[op] {
+			return ErrInstanceNotFound
+		}
+		return ErrServiceNotFound
+	case namingpb.NotFoundService:
+		return ErrServiceNotFound
+	}
+	switch sdkErr.ErrorCode() {
+	case model.ErrCodeServiceNotFound:
+		return ErrServiceNotFound
+	case model.ErrCodeRouteRuleNotMatch:
+		return ErrNoRoutedInstance
+	case model.ErrCodeAPITimeoutError, model.ErrCodeNetworkError, model.ErrCodeServerException,
+		model.ErrCodeConnectError, model.ErrCodeServerError, model.ErrCodeInvalidStateError:
+		return ErrControlPlaneUnavailable
+	}
+	return nil
+}
+
+// rejectedByServer reports whether polaris refused the request with a code the SDK didn't report.
+func rejectedByServer(err *PolarisError) bool {
+	sdkErr, ok := err.Err.(model.SDKError)
+	return ok && err.Kind == nil && sdkErr.ErrorCode() == model.ErrCodeServerUserError && sdkErr.ServerCode() == 0
+}
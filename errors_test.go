@@ -0,0 +1,53 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	namingpb "github.com/polarismesh/polaris-go/pkg/model/pb/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorKind(t *testing.T) {
+	err := model.NewServerSDKError(namingpb.NotFoundInstance, "not found instance", nil, "fail to heartbeat")
+	require.Equal(t, ErrInstanceNotFound, errorKind("Heartbeat", err))
+	err = model.NewServerSDKError(namingpb.NotFoundResource, "not found resource", nil, "fail to deregister")
+	require.Equal(t, ErrInstanceNotFound, errorKind("Deregister", err))
+	require.Equal(t, ErrServiceNotFound, errorKind("GetInstances", err))
+	require.Equal(t, ErrServiceNotFound, errorKind("WatchService", err))
+	err = model.NewServerSDKError(namingpb.InvalidParameter, "invalid parameter", nil, "fail to heartbeat")
+	require.Nil(t, errorKind("Heartbeat", err))
+	err = model.NewSDKError(model.ErrCodeServiceNotFound, nil, "service not found")
+	require.Equal(t, ErrServiceNotFound, errorKind("GetInstances", err))
+	err = model.NewSDKError(model.ErrCodeAPITimeoutError, nil, "timeout")
+	require.Equal(t, ErrControlPlaneUnavailable, errorKind("GetInstances", err))
+	require.Nil(t, errorKind("GetInstances", errors.New("not an SDK error")))
+
+	// the heartbeat of an instance unknown to polaris
+	provider := api.NewProviderAPIByContext(testClient.SDKContext())
+	hbErr := provider.Heartbeat(&api.InstanceHeartbeatRequest{InstanceHeartbeatRequest: model.InstanceHeartbeatRequest{
+		Namespace: DefaultPolarisNamespace, Service: "errors-unknown", Host: "127.0.0.1", Port: 8899,
+	}})
+	require.NotNil(t, hbErr)
+	// the SDK doesn't report its code, the registry registers the instance again to find out it was lost
+	require.True(t, rejectedByServer(newPolarisError("Heartbeat", "errors-unknown", hbErr)), hbErr)
+	require.False(t, rejectedByServer(newPolarisError("Heartbeat", "errors-unknown", err)))
+}
@@ -23,7 +23,7 @@ import (
 
 	"github.com/cloudwego/kitex-examples/hello/kitex_gen/api"
 	"github.com/cloudwego/kitex-examples/hello/kitex_gen/api/hello"
-	"github.com/cloudwego/kitex/pkg/registry"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
 	"github.com/cloudwego/kitex/server"
 	"github.com/kitex-contrib/polaris"
 )
@@ -45,35 +45,17 @@ func (h *HelloImpl) Echo(ctx context.Context, req *api.Request) (resp *api.Respo
 }
 
 func main() {
-	so := polaris.ServerOptions{}
-	r, err := polaris.NewPolarisRegistry(so)
-	if err != nil {
-		log.Fatal(err)
-	}
 	svcName := "polaris.ratelimit.echo"
-	Info := &registry.Info{
-		ServiceName: svcName,
-		Tags: map[string]string{
-			polaris.NameSpaceTagKey: Namespace,
-		},
-	}
-
-	qpsLimiter, err := polaris.NewQPSLimiter()
-	if err != nil {
-		log.Fatal(err)
-	}
-	qpsLimiter.WithNamespace(Namespace)
-	qpsLimiter.WithServiceName(svcName)
-
 	newServer := hello.NewServer(
 		new(HelloImpl),
-		server.WithRegistry(r),
-		server.WithRegistryInfo(Info),
+		server.WithSuite(&polaris.ServerSuite{
+			ServerOptions: polaris.ServerOptions{Namespace: Namespace},
+		}),
+		server.WithServerBasicInfo(&rpcinfo.EndpointBasicInfo{ServiceName: svcName}),
 		server.WithServiceAddr(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8890}),
-		server.WithQPSLimiter(qpsLimiter),
 	)
 
-	err = newServer.Run()
+	err := newServer.Run()
 	if err != nil {
 		log.Fatal(err)
 	}
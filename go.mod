@@ -3,10 +3,13 @@ module github.com/kitex-contrib/polaris
 go 1.16
 
 require (
+	github.com/bytedance/gopkg v0.0.0-20220509134931-d1878f638986
 	github.com/cloudwego/kitex v0.3.2
 	github.com/cloudwego/kitex-examples v0.1.0
+	github.com/golang/protobuf v1.5.2
 	github.com/pkg/errors v0.9.1
 	github.com/polarismesh/polaris-go v1.2.0-beta.0.0.20220625150934-9ebd65d7dd37
 	github.com/stretchr/testify v1.7.5
 	golang.org/x/sync v0.0.0-20220601150217-0de741cfad7f
+	google.golang.org/grpc v1.46.2
 )
@@ -18,11 +18,20 @@ package polaris
 
 import (
 	"net"
+	"strconv"
+	"strings"
 
 	"github.com/cloudwego/kitex/pkg/discovery"
 	"github.com/polarismesh/polaris-go/pkg/model"
 )
 
+// Tags of the discovered instances exposing the attributes of polaris instances
+// which are not set by the tags of registry.Info, such as VersionTagKey.
+const (
+	InstanceIDTagKey = "instance_id"
+	ProtocolTagKey   = "protocol"
+)
+
 type polarisKitexInstance struct {
 	kitexInstance   discovery.Instance
 	polarisInstance model.Instance
@@ -37,7 +46,47 @@ func (i *polarisKitexInstance) Weight() int {
 	return i.kitexInstance.Weight()
 }
 
+// Tag returns the namespace or an attribute of the polaris instance, then its metadata
+// with the key prefixed by ClientOptions.MetadataTagPrefix.
+// The attributes take precedence over the metadata when they have the same key.
 func (i *polarisKitexInstance) Tag(key string) (value string, exist bool) {
-	value, exist = i.kitexInstance.Tag(key)
+	if value, exist = i.kitexInstance.Tag(key); exist {
+		return
+	}
+	if value, exist = i.attribute(key); exist {
+		return
+	}
+	prefix := i.polarisOptions.MetadataTagPrefix
+	if !strings.HasPrefix(key, prefix) {
+		return "", false
+	}
+	value, exist = i.polarisInstance.GetMetadata()[key[len(prefix):]]
 	return
 }
+
+// attribute returns the attribute of the polaris instance for a tag key, empty attributes don't exist.
+func (i *polarisKitexInstance) attribute(key string) (string, bool) {
+	ins := i.polarisInstance
+	var value string
+	switch key {
+	case InstanceIDTagKey:
+		value = ins.GetId()
+	case ProtocolTagKey:
+		value = ins.GetProtocol()
+	case VersionTagKey:
+		value = ins.GetVersion()
+	case RegionTagKey:
+		value = ins.GetRegion()
+	case ZoneTagKey:
+		value = ins.GetZone()
+	case CampusTagKey:
+		value = ins.GetCampus()
+	case PriorityTagKey:
+		return strconv.FormatUint(uint64(ins.GetPriority()), 10), true
+	case HealthyTagKey:
+		return strconv.FormatBool(ins.IsHealthy()), true
+	case IsolateTagKey:
+		return strconv.FormatBool(ins.IsIsolated()), true
+	}
+	return value, len(value) != 0
+}
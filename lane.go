@@ -0,0 +1,151 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bytedance/gopkg/cloud/metainfo"
+	"github.com/cloudwego/kitex/pkg/endpoint"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+const (
+	// LaneMetainfoKey is the default transient metainfo key carrying the lane of the requests.
+	LaneMetainfoKey = "polaris-lane"
+	// LaneMetadataKey is the default metadata key of the lane of the instances, the baseline instances have none.
+	LaneMetadataKey = "lane"
+)
+
+// LaneFallback decides where the requests of a lane go when the lane has no instance.
+type LaneFallback int
+
+const (
+	// LaneFallbackBaseline picks among the baseline instances, or all the instances if there is no baseline one.
+	LaneFallbackBaseline LaneFallback = iota
+	// LaneFallbackAll picks among all the instances.
+	LaneFallbackAll
+	// LaneFallbackFail fails the requests with ErrNoRoutedInstance.
+	LaneFallbackFail
+)
+
+// LaneOptions keeps the requests of a lane on the instances of the lane across every hop,
+// the requests without lane go to the baseline instances.
+type LaneOptions struct {
+	Enable bool `json:"enable"`
+	// MetainfoKey is the transient metainfo key carrying the lane, LaneMetainfoKey if empty.
+	MetainfoKey string `json:"metainfo_key"`
+	// MetadataKey is the metadata key of the lane of the instances, LaneMetadataKey if empty.
+	// The lane is also a routing label of this key.
+	MetadataKey string `json:"metadata_key"`
+	// Fallback decides where the requests go when their lane has no instance.
+	Fallback LaneFallback `json:"fallback"`
+}
+
+func (lo LaneOptions) metainfoKey() string {
+	if lo.MetainfoKey == "" {
+		return LaneMetainfoKey
+	}
+	return lo.MetainfoKey
+}
+
+func (lo LaneOptions) metadataKey() string {
+	if lo.MetadataKey == "" {
+		return LaneMetadataKey
+	}
+	return lo.MetadataKey
+}
+
+// WithLane returns a copy of ctx whose requests are in lane, such as at the edge of the gray environment.
+func WithLane(ctx context.Context, lane string, lo LaneOptions) context.Context {
+	return metainfo.WithValue(ctx, lo.metainfoKey(), lane)
+}
+
+// GetLane returns the lane of the requests of ctx, empty for the baseline.
+func GetLane(ctx context.Context, lo LaneOptions) string {
+	lane, _ := metainfo.GetValue(ctx, lo.metainfoKey())
+	return lane
+}
+
+// laneKey is the context key of the lane of a call set by NewLaneClientMW.
+type laneKey struct{}
+
+// NewLaneServerMW turns the lane received from the caller into a transient value,
+// so the calls made by the handler carry it to the next hop.
+func NewLaneServerMW(lo LaneOptions) endpoint.Middleware {
+	key := lo.metainfoKey()
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request, response interface{}) error {
+			if lane, ok := metainfo.GetValue(ctx, key); ok && lane != "" {
+				ctx = metainfo.WithValue(ctx, key, lane)
+			}
+			return next(ctx, request, response)
+		}
+	}
+}
+
+// NewLaneClientMW hands the lane sent to the callee to the polaris picker, which routes the call by it
+// instead of reading the metainfo itself. A lane received by the handler but not carried on by NewLaneServerMW
+// is not sent, so the call is routed to the baseline like the calls of the callee will be.
+func NewLaneClientMW(lo LaneOptions) endpoint.Middleware {
+	key := lo.metainfoKey()
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request, response interface{}) error {
+			// the values received by the callee are the transient ones of the caller
+			lane, _ := metainfo.GetValue(metainfo.TransferForward(ctx), key)
+			return next(context.WithValue(ctx, laneKey{}, lane), request, response)
+		}
+	}
+}
+
+// laneOf returns the lane the picker routes the call of ctx by, the one set by NewLaneClientMW if any.
+func laneOf(ctx context.Context, lo LaneOptions) string {
+	if lane, ok := ctx.Value(laneKey{}).(string); ok {
+		return lane
+	}
+	return GetLane(ctx, lo)
+}
+
+// filter returns the instances of the lane, or the baseline ones when lane is empty.
+func (lo LaneOptions) filter(desc, lane string, instances []model.Instance) ([]model.Instance, error) {
+	key := lo.metadataKey()
+	var laneInstances, baseline []model.Instance
+	for _, ins := range instances {
+		switch insLane := ins.GetMetadata()[key]; {
+		case insLane == "":
+			baseline = append(baseline, ins)
+		case lane != "" && insLane == lane:
+			laneInstances = append(laneInstances, ins)
+		}
+	}
+	if lane != "" {
+		if len(laneInstances) != 0 {
+			return laneInstances, nil
+		}
+		switch lo.Fallback {
+		case LaneFallbackAll:
+			return instances, nil
+		case LaneFallbackFail:
+			return nil, fmt.Errorf("%w: %s has no instance in lane %s", ErrNoRoutedInstance, desc, lane)
+		}
+	}
+	if len(baseline) == 0 {
+		return instances, nil
+	}
+	return baseline, nil
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/bytedance/gopkg/cloud/metainfo"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kitex-contrib/polaris/polaristest"
+)
+
+func TestLaneMW(t *testing.T) {
+	lo := LaneOptions{Enable: true}
+	// the lane received from the caller is not sent to the next hop
+	received := metainfo.TransferForward(WithLane(context.Background(), "gray", lo))
+	require.Equal(t, "", GetLane(metainfo.TransferForward(received), lo))
+
+	// the server middleware passes it on
+	var sent, picked string
+	handler := func(ctx context.Context, req, resp interface{}) error {
+		sent = GetLane(metainfo.TransferForward(ctx), lo)
+		picked = laneOf(ctx, lo)
+		return nil
+	}
+	require.Nil(t, NewLaneServerMW(lo)(handler)(received, nil, nil))
+	require.Equal(t, "gray", sent)
+
+	// the client middleware hands the lane sent to the picker
+	client := NewLaneClientMW(lo)(handler)
+	require.Nil(t, NewLaneServerMW(lo)(client)(received, nil, nil))
+	require.Equal(t, "gray", picked)
+	require.Nil(t, client(received, nil, nil))
+	require.Equal(t, "", sent)
+	require.Equal(t, "", picked)
+	require.Equal(t, "gray", laneOf(received, lo))
+}
+
+func TestPolarisPickerLane(t *testing.T) {
+	svcName := "balancer-lane"
+	testServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{Host: "127.0.0.1", Port: 9071})
+	testServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{
+		Host: "127.0.0.1", Port: 9072, Metadata: map[string]string{LaneMetadataKey: "gray"},
+	})
+
+	pickCtx := func(lo LaneOptions, ctx context.Context) (string, error) {
+		rs, err := NewPolarisResolver(ClientOptions{Lane: lo}, WithPolarisClient(testClient))
+		require.Nil(t, err)
+		lb, err := NewPolarisBalancer(WithPolarisClient(testClient))
+		require.Nil(t, err)
+		defer lb.Destroy()
+		result := resolveForBalancer(t, rs, svcName)
+		pe := &pickError{}
+		ctx = context.WithValue(ctx, pickErrorKey{}, pe)
+		ins := lb.GetPicker(result).Next(ctx, nil)
+		if ins == nil {
+			return "", pe.err
+		}
+		return ins.Address().String(), nil
+	}
+	pick := func(lo LaneOptions, lane string) (string, error) {
+		ctx := newRPCInfoCtx(svcName, "Echo")
+		if lane != "" {
+			ctx = WithLane(ctx, lane, lo)
+		}
+		return pickCtx(lo, ctx)
+	}
+
+	lo := LaneOptions{Enable: true}
+	for i := 0; i < 10; i++ {
+		addr, err := pick(lo, "")
+		require.Nil(t, err)
+		require.Equal(t, "127.0.0.1:9071", addr)
+		addr, err = pick(lo, "gray")
+		require.Nil(t, err)
+		require.Equal(t, "127.0.0.1:9072", addr)
+		addr, err = pick(lo, "blue")
+		require.Nil(t, err)
+		require.Equal(t, "127.0.0.1:9071", addr)
+	}
+	_, err := pick(LaneOptions{Enable: true, Fallback: LaneFallbackFail}, "blue")
+	require.True(t, errors.Is(err, ErrNoRoutedInstance), err)
+	picked := map[string]bool{}
+	for i := 0; i < 50; i++ {
+		addr, err := pick(LaneOptions{Enable: true, Fallback: LaneFallbackAll}, "blue")
+		require.Nil(t, err)
+		picked[addr] = true
+	}
+	require.Len(t, picked, 2)
+
+	// NewLaneClientMW keeps a lane received but not sent from routing the call
+	received := metainfo.TransferForward(WithLane(newRPCInfoCtx(svcName, "Echo"), "gray", lo))
+	addr, err := pickCtx(lo, received)
+	require.Nil(t, err)
+	require.Equal(t, "127.0.0.1:9072", addr)
+	err = NewLaneClientMW(lo)(func(ctx context.Context, req, resp interface{}) error {
+		addr, err = pickCtx(lo, ctx)
+		return err
+	})(received, nil, nil)
+	require.Nil(t, err)
+	require.Equal(t, "127.0.0.1:9071", addr)
+}
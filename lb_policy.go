@@ -0,0 +1,128 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"sync"
+
+	"github.com/bytedance/gopkg/cloud/metainfo"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/polarismesh/polaris-go/pkg/config"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+// Load balancers of the polaris balancer, LBWeightedRoundRobin is implemented by the balancer,
+// the others by the polaris SDK.
+const (
+	LBWeightedRandom     = config.DefaultLoadBalancerWR
+	LBRingHash           = config.DefaultLoadBalancerRingHash
+	LBMaglev             = config.DefaultLoadBalancerMaglev
+	LBHash               = config.DefaultLoadBalancerHash
+	LBWeightedRoundRobin = "weightedRoundRobin"
+)
+
+// HashKeyFunc returns the key of a request for the hash based load balancers.
+// A request without key is balanced by weighted random.
+type HashKeyFunc func(ctx context.Context, request interface{}) []byte
+
+// LBPolicy is a load balancing policy of the polaris balancer.
+type LBPolicy struct {
+	// LoadBalancer is the load balancer, such as LBRingHash, LBWeightedRandom if empty.
+	LoadBalancer string
+	// HashKey returns the key of the requests for the hash based load balancers.
+	HashKey HashKeyFunc
+	// ReplicateCount is the number of virtual nodes per instance of the consistent hash rings, the SDK default if zero.
+	ReplicateCount int
+}
+
+// BalancerOptions configures the load balancing of the polaris balancer.
+type BalancerOptions struct {
+	Policy LBPolicy
+	// MethodPolicies overrides Policy for some methods.
+	MethodPolicies map[string]LBPolicy
+	// ExcludedFallback decides what to do when the instances tried by the retries of an RPC leave none to pick.
+	ExcludedFallback ExcludedFallback
+	// RouteCache configures the cache of the routed instances.
+	RouteCache RouteCacheOptions
+}
+
+// policy returns the policy of the method.
+func (bo BalancerOptions) policy(method string) LBPolicy {
+	if p, ok := bo.MethodPolicies[method]; ok {
+		return p
+	}
+	return bo.Policy
+}
+
+// HashKeyByMethod hashes the requests by their method, so the calls of a method go to the same instance.
+func HashKeyByMethod(ctx context.Context, request interface{}) []byte {
+	ri := rpcinfo.GetRPCInfo(ctx)
+	if ri == nil {
+		return nil
+	}
+	return []byte(ri.To().Method())
+}
+
+// HashKeyByMetainfo hashes the requests by the value of a transient or persistent metainfo key, such as a session id.
+func HashKeyByMetainfo(key string) HashKeyFunc {
+	return func(ctx context.Context, request interface{}) []byte {
+		if value, ok := metainfo.GetValue(ctx, key); ok {
+			return []byte(value)
+		}
+		if value, ok := metainfo.GetPersistentValue(ctx, key); ok {
+			return []byte(value)
+		}
+		return nil
+	}
+}
+
+// weightedRoundRobin is the smooth weighted round robin of nginx, the state of each instance
+// is kept across the pickers of a service so the routed subsets are balanced too.
+type weightedRoundRobin struct {
+	lock    sync.Mutex
+	current map[string]int
+}
+
+// next picks an instance among instances, nil if all of them have weight 0.
+func (w *weightedRoundRobin) next(instances []model.Instance) model.Instance {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.current == nil {
+		w.current = make(map[string]int, len(instances))
+	}
+	var (
+		best  model.Instance
+		total int
+	)
+	for _, ins := range instances {
+		weight := ins.GetWeight()
+		if weight <= 0 {
+			continue
+		}
+		total += weight
+		w.current[ins.GetId()] += weight
+		if best == nil || w.current[ins.GetId()] > w.current[best.GetId()] {
+			best = ins
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	w.current[best.GetId()] -= total
+	return best
+}
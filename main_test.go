@@ -0,0 +1,50 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/kitex-contrib/polaris/polaristest"
+)
+
+var (
+	// testServer is the fake Polaris server shared by the tests of this package.
+	testServer *polaristest.Server
+	// testClient is the polaris client connected to testServer.
+	testClient *PolarisClient
+)
+
+func TestMain(m *testing.M) {
+	var err error
+	testServer, err = polaristest.NewServer()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fail to start fake polaris server: %v\n", err)
+		os.Exit(1)
+	}
+	testClient, err = NewPolarisClient(testServer.ConfigFile())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fail to init polaris client: %v\n", err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	testClient.Destroy()
+	testServer.Close()
+	os.Exit(code)
+}
@@ -18,13 +18,16 @@ package polaris
 
 import (
 	"context"
-	"sync"
+	"errors"
 	"time"
 
+	"github.com/bytedance/gopkg/cloud/metainfo"
 	"github.com/cloudwego/kitex/pkg/endpoint"
+	"github.com/cloudwego/kitex/pkg/kerrors"
 	"github.com/cloudwego/kitex/pkg/rpcinfo"
 	"github.com/cloudwego/kitex/pkg/rpcinfo/remoteinfo"
 	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/log"
 )
 
 const (
@@ -32,46 +35,151 @@ const (
 	retFailCode    = -1
 )
 
-// NewUpdateServiceCallResultMW report call result for circuitbreak
-func NewUpdateServiceCallResultMW(configFile ...string) endpoint.Middleware {
+const (
+	// MethodLabelKey is the label of the rate limit rules matching the method of the requests.
+	MethodLabelKey = "method"
+	// CallerServiceLabelKey is the label of the rate limit rules matching the service of the callers.
+	CallerServiceLabelKey = "caller_service"
+)
+
+// NewUpdateServiceCallResultMW report call result for circuitbreak.
+// When the polaris balancer picks no instance, the cause of the kerrors.ErrNoMoreInstance returned
+// is replaced by the reason, such as ErrNoRoutedInstance.
+// The middleware takes a reference on the polaris client when Kitex builds it and keeps it for its lifetime,
+// ClientSuite releases the reference of the middleware it creates when the Kitex client is closed.
+func NewUpdateServiceCallResultMW(opts ...Option) endpoint.Middleware {
 	return func(next endpoint.Endpoint) endpoint.Endpoint {
-		var (
-			callResultSdkCtx      api.SDKContext
-			callResultConsumerAPI api.ConsumerAPI
-			callResultOnce        sync.Once
-		)
-		var err error
-		callResultOnce.Do(func() {
-			callResultSdkCtx, err = GetPolarisConfig(configFile...)
-			callResultConsumerAPI = api.NewConsumerAPIByContext(callResultSdkCtx)
-		})
+		ref, err := newClientRef(opts)
 		if err != nil {
-			return func(ctx context.Context, req, resp interface{}) (err error) {
+			return func(ctx context.Context, req, resp interface{}) error {
 				return err
 			}
 		}
-		return func(ctx context.Context, request, response interface{}) error {
-			retCode := int32(retSuccessCode)
-			retStatus := api.RetSuccess
-			begin := time.Now()
-			kitexCallErr := next(ctx, request, response)
-			cost := time.Since(begin)
-			if kitexCallErr != nil {
-				retCode = retFailCode
-				retStatus = api.RetFail
+		return callResultEndpoint(ref, next)
+	}
+}
+
+// callResultEndpoint reports the result of the calls made by next with the client of ref.
+func callResultEndpoint(ref *clientRef, next endpoint.Endpoint) endpoint.Endpoint {
+	consumer := api.NewConsumerAPIByContext(ref.SDKContext())
+	return func(ctx context.Context, request, response interface{}) error {
+		pe := &pickError{}
+		ctx = context.WithValue(ctx, pickErrorKey{}, pe)
+		retCode := int32(retSuccessCode)
+		retStatus := api.RetSuccess
+		begin := time.Now()
+		kitexCallErr := next(ctx, request, response)
+		cost := time.Since(begin)
+		if kitexCallErr != nil {
+			retCode = retFailCode
+			retStatus = api.RetFail
+		}
+
+		ins, ok := calledInstance(ctx)
+		if !ok {
+			if pe.err != nil && errors.Is(kitexCallErr, kerrors.ErrNoMoreInstance) {
+				return kerrors.ErrNoMoreInstance.WithCause(pe.err)
 			}
+			return kitexCallErr
+		}
 
-			svcCallResult := &api.ServiceCallResult{}
+		svcCallResult := &api.ServiceCallResult{}
+		svcCallResult.CalledInstance = ins.polarisInstance
 
+		svcCallResult.SetRetCode(retCode)
+		svcCallResult.SetRetStatus(retStatus)
+		svcCallResult.SetDelay(cost)
+		// 执行调用结果上报
+		_ = consumer.UpdateServiceCallResult(svcCallResult)
+		return kitexCallErr
+	}
+}
+
+// calledInstance returns the polaris instance the request was sent to, if any.
+func calledInstance(ctx context.Context) (*polarisKitexInstance, bool) {
+	ri := rpcinfo.GetRPCInfo(ctx)
+	if ri == nil {
+		return nil, false
+	}
+	remote, ok := ri.To().(remoteinfo.RemoteInfo)
+	if !ok {
+		return nil, false
+	}
+	ins, ok := remote.GetInstance().(*polarisKitexInstance)
+	return ins, ok
+}
+
+// RateLimitOptions selects the labels of the quota requests made by NewRateLimitMW.
+type RateLimitOptions struct {
+	// Namespace is the namespace of the service, DefaultPolarisNamespace if empty.
+	Namespace string
+	// MetadataLabels are the keys of the transient metadata sent by the callers, used as labels of the same name.
+	MetadataLabels []string
+	// LabelExtractor returns additional labels of a request, such as the fields of req.
+	LabelExtractor func(ctx context.Context, req interface{}) map[string]string
+	// Mode is how the middleware waits for the quota and handles the errors of polaris.
+	Mode LimiterMode
+	// Observer is called with the decision on every request, including why it is rejected.
+	Observer LimiterObserver
+}
+
+// NewRateLimitMW limits the requests of a server by the polaris rate limit rules of its service.
+// The quota is requested with the labels MethodLabelKey and CallerServiceLabelKey
+// set to the method and the caller service of the request, and the labels selected by ro.
+// Requests are rejected with kerrors.ErrQPSOverLimit when the quota is denied.
+// The middleware takes a reference on the polaris client when Kitex builds it and keeps it
+// as long as the process runs, since Kitex doesn't tell the middlewares when the server stops.
+func NewRateLimitMW(ro RateLimitOptions, opts ...Option) endpoint.Middleware {
+	namespace := ro.Namespace
+	if len(namespace) == 0 {
+		namespace = DefaultPolarisNamespace
+	}
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		ref, err := newClientRef(opts)
+		if err != nil {
+			return func(ctx context.Context, req, resp interface{}) error {
+				return err
+			}
+		}
+		limitAPI := api.NewLimitAPIByContext(ref.SDKContext())
+		return func(ctx context.Context, request, response interface{}) error {
 			ri := rpcinfo.GetRPCInfo(ctx)
-			svcCallResult.CalledInstance = ri.To().(remoteinfo.RemoteInfo).GetInstance().(*polarisKitexInstance).polarisInstance
+			svcName := ri.To().ServiceName()
+			quotaReq := api.NewQuotaRequest()
+			quotaReq.SetNamespace(namespace)
+			quotaReq.SetService(svcName)
+			quotaReq.SetLabels(ro.labels(ctx, ri, request))
+			reason, err := acquireQuota(ctx, limitAPI, quotaReq, ro.Mode)
+			if err != nil {
+				log.GetBaseLogger().Errorf("fail to do GetQuota, err is %v", err)
+			}
+			if ro.Observer != nil {
+				ro.Observer(LimiterEvent{Namespace: namespace, Service: svcName, Reason: reason, Err: err})
+			}
+			if len(reason) != 0 {
+				return kerrors.ErrQPSOverLimit
+			}
+			return next(ctx, request, response)
+		}
+	}
+}
 
-			svcCallResult.SetRetCode(retCode)
-			svcCallResult.SetRetStatus(retStatus)
-			svcCallResult.SetDelay(cost)
-			// 执行调用结果上报
-			_ = callResultConsumerAPI.UpdateServiceCallResult(svcCallResult)
-			return kitexCallErr
+// labels returns the labels of the quota request of req, the labels given by
+// LabelExtractor take precedence over the metadata, which take precedence over the method and the caller.
+func (ro RateLimitOptions) labels(ctx context.Context, ri rpcinfo.RPCInfo, req interface{}) map[string]string {
+	labels := map[string]string{MethodLabelKey: ri.Invocation().MethodName()}
+	if from := ri.From(); from != nil && len(from.ServiceName()) != 0 {
+		labels[CallerServiceLabelKey] = from.ServiceName()
+	}
+	for _, key := range ro.MetadataLabels {
+		if v, ok := metainfo.GetValue(ctx, key); ok {
+			labels[key] = v
+		}
+	}
+	if ro.LabelExtractor != nil {
+		for k, v := range ro.LabelExtractor(ctx, req) {
+			labels[k] = v
 		}
 	}
+	return labels
 }
@@ -0,0 +1,102 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bytedance/gopkg/cloud/metainfo"
+	"github.com/cloudwego/kitex/pkg/kerrors"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/kitex-contrib/polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitMW(t *testing.T) {
+	svcName := "ratelimit-mw"
+	rule := polaristest.MatchLabels(polaristest.QPSRule(1, time.Minute), map[string]string{MethodLabelKey: "Echo"})
+	testServer.SetRateLimit(DefaultPolarisNamespace, svcName, rule)
+
+	var (
+		calls  int
+		events []LimiterEvent
+	)
+	ro := RateLimitOptions{Observer: func(event LimiterEvent) { events = append(events, event) }}
+	ep := NewRateLimitMW(ro, WithPolarisClient(testClient))(func(ctx context.Context, req, resp interface{}) error {
+		calls++
+		return nil
+	})
+
+	echo := newRPCInfoCtx(svcName, "Echo")
+	require.Nil(t, ep(echo, nil, nil))
+	err := ep(echo, nil, nil)
+	require.True(t, errors.Is(err, kerrors.ErrQPSOverLimit))
+	require.Equal(t, []LimiterEvent{
+		{Namespace: DefaultPolarisNamespace, Service: svcName},
+		{Namespace: DefaultPolarisNamespace, Service: svcName, Reason: RejectLimited},
+	}, events)
+
+	// methods have their own quota
+	ping := newRPCInfoCtx(svcName, "Ping")
+	for i := 0; i < 3; i++ {
+		require.Nil(t, ep(ping, nil, nil))
+	}
+	require.Equal(t, 4, calls)
+}
+
+func TestRateLimitMWLabels(t *testing.T) {
+	svcName := "ratelimit-mw-labels"
+	testServer.SetRateLimit(DefaultPolarisNamespace, svcName,
+		polaristest.MatchLabels(polaristest.QPSRule(1, time.Minute), map[string]string{CallerServiceLabelKey: "frontend"}),
+		polaristest.MatchLabels(polaristest.QPSRule(1, time.Minute), map[string]string{"tenant": "a"}),
+		polaristest.MatchLabels(polaristest.QPSRule(1, time.Minute), map[string]string{"user": "bob"}),
+	)
+
+	ro := RateLimitOptions{
+		MetadataLabels: []string{"tenant"},
+		LabelExtractor: func(ctx context.Context, req interface{}) map[string]string {
+			return map[string]string{"user": req.(string)}
+		},
+	}
+	ep := NewRateLimitMW(ro, WithPolarisClient(testClient))(func(ctx context.Context, req, resp interface{}) error {
+		return nil
+	})
+	newCtx := func(caller string) context.Context {
+		from := rpcinfo.NewEndpointInfo(caller, "", nil, nil)
+		to := rpcinfo.NewEndpointInfo(svcName, "Echo", nil, nil)
+		ri := rpcinfo.NewRPCInfo(from, to, rpcinfo.NewInvocation(svcName, "Echo"), nil, nil)
+		return rpcinfo.NewCtxWithRPCInfo(context.Background(), ri)
+	}
+
+	// by caller
+	require.Nil(t, ep(newCtx("frontend"), "alice", nil))
+	require.True(t, errors.Is(ep(newCtx("frontend"), "alice", nil), kerrors.ErrQPSOverLimit))
+	require.Nil(t, ep(newCtx("backend"), "alice", nil))
+
+	// by transient metadata
+	ctx := metainfo.WithValue(newCtx("backend"), "tenant", "a")
+	require.Nil(t, ep(ctx, "alice", nil))
+	require.True(t, errors.Is(ep(ctx, "alice", nil), kerrors.ErrQPSOverLimit))
+
+	// by request
+	require.Nil(t, ep(newCtx("backend"), "bob", nil))
+	require.True(t, errors.Is(ep(newCtx("backend"), "bob", nil), kerrors.ErrQPSOverLimit))
+	require.Nil(t, ep(newCtx("backend"), "alice", nil))
+}
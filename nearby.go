@@ -0,0 +1,188 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+// Levels of the nearby routing, from the narrowest to the widest.
+const (
+	NearbyCampus = "campus"
+	NearbyZone   = "zone"
+	NearbyRegion = "region"
+	NearbyAll    = "all"
+)
+
+// Environment variables holding the location of the client.
+const (
+	EnvRegion = "POLARIS_INSTANCE_REGION"
+	EnvZone   = "POLARIS_INSTANCE_ZONE"
+	EnvCampus = "POLARIS_INSTANCE_CAMPUS"
+)
+
+var nearbyLevels = map[string]int{
+	NearbyAll:    0,
+	NearbyRegion: 1,
+	NearbyZone:   2,
+	NearbyCampus: 3,
+}
+
+// Location is where the client or an instance runs.
+type Location struct {
+	Region string `json:"region"`
+	Zone   string `json:"zone"`
+	Campus string `json:"campus"`
+}
+
+// String implements the fmt.Stringer interface.
+func (l Location) String() string {
+	return l.Region + "/" + l.Zone + "/" + l.Campus
+}
+
+// NearbyOptions keeps the traffic on the instances closest to the client,
+// it spills over to a wider level only when the closer instances are unhealthy.
+//
+// The nearby router of the polaris SDK is not used: its location and levels are set once per SDK context,
+// which the clients of all the services share, and it applies only to the services enabling it in their metadata.
+// The options are applied to the instances returned by ProcessRouters instead, so each client sets its own.
+type NearbyOptions struct {
+	Enable bool `json:"enable"`
+	// Location is the location of the client, its empty parts are taken from the region, zone and campus keys
+	// of ClientOptions.SrcMetadata, then from the POLARIS_INSTANCE_REGION, ZONE and CAMPUS environment variables.
+	Location Location `json:"location"`
+	// MatchLevel is the level the instances are preferred at, NearbyZone if empty.
+	MatchLevel string `json:"match_level"`
+	// MaxMatchLevel is the widest level the traffic spills over to, NearbyAll if empty.
+	MaxMatchLevel string `json:"max_match_level"`
+	// UnhealthyPercentToDegrade spills the traffic over to the next level when at least this percent of the instances
+	// of a level are unhealthy, zero spills it over only when all of them are.
+	UnhealthyPercentToDegrade int `json:"unhealthy_percent_to_degrade"`
+	// Strict fails the requests when no level up to MaxMatchLevel has healthy instances,
+	// instead of picking among all the routed instances.
+	Strict bool `json:"strict"`
+}
+
+func (no NearbyOptions) validate() error {
+	if !no.Enable {
+		return nil
+	}
+	match, max := no.levels()
+	if match < 0 {
+		return fmt.Errorf("invalid nearby match level %q", no.MatchLevel)
+	}
+	if max < 0 {
+		return fmt.Errorf("invalid nearby max match level %q", no.MaxMatchLevel)
+	}
+	if max > match {
+		return fmt.Errorf("nearby max match level %s is narrower than match level %s", no.MaxMatchLevel, no.MatchLevel)
+	}
+	if no.UnhealthyPercentToDegrade < 0 || no.UnhealthyPercentToDegrade > 100 {
+		return fmt.Errorf("invalid nearby unhealthy percent to degrade %d", no.UnhealthyPercentToDegrade)
+	}
+	return nil
+}
+
+// levels returns the match level and the max match level, -1 if unknown.
+func (no NearbyOptions) levels() (match, max int) {
+	return nearbyLevel(no.MatchLevel, NearbyZone), nearbyLevel(no.MaxMatchLevel, NearbyAll)
+}
+
+func nearbyLevel(name, defaultName string) int {
+	if name == "" {
+		name = defaultName
+	}
+	if level, ok := nearbyLevels[name]; ok {
+		return level
+	}
+	return -1
+}
+
+// clientLocation completes l from the source metadata and the environment.
+func clientLocation(l Location, srcMetadata map[string]string) Location {
+	fill := func(part *string, key, env string) {
+		if *part == "" {
+			*part = srcMetadata[key]
+		}
+		if *part == "" {
+			*part = os.Getenv(env)
+		}
+	}
+	fill(&l.Region, RegionTagKey, EnvRegion)
+	fill(&l.Zone, ZoneTagKey, EnvZone)
+	fill(&l.Campus, CampusTagKey, EnvCampus)
+	return l
+}
+
+// filter returns the healthy routed instances of the narrowest level with enough healthy instances.
+func (no NearbyOptions) filter(desc string, instances []model.Instance) ([]model.Instance, error) {
+	match, max := no.levels()
+	for level := match; level > nearbyLevels[NearbyAll] && level >= max; level-- {
+		if !no.Location.known(level) {
+			continue
+		}
+		var (
+			healthy []model.Instance
+			matched int
+		)
+		for _, ins := range instances {
+			if no.Location.matches(ins, level) {
+				matched++
+				if instanceHealthy(ins) {
+					healthy = append(healthy, ins)
+				}
+			}
+		}
+		if len(healthy) == 0 {
+			continue
+		}
+		if no.UnhealthyPercentToDegrade > 0 && (matched-len(healthy))*100 >= no.UnhealthyPercentToDegrade*matched {
+			continue
+		}
+		return healthy, nil
+	}
+	if max > nearbyLevels[NearbyAll] && no.Strict {
+		return nil, fmt.Errorf("%w: no healthy instance of %s near %s", ErrNoRoutedInstance, desc, no.Location)
+	}
+	return instances, nil
+}
+
+// known reports whether the parts of the location compared at level are set.
+func (l Location) known(level int) bool {
+	return (level < nearbyLevels[NearbyRegion] || l.Region != "") &&
+		(level < nearbyLevels[NearbyZone] || l.Zone != "") &&
+		(level < nearbyLevels[NearbyCampus] || l.Campus != "")
+}
+
+// matches reports whether ins runs at the same location up to level.
+func (l Location) matches(ins model.Instance, level int) bool {
+	return (level < nearbyLevels[NearbyRegion] || ins.GetRegion() == l.Region) &&
+		(level < nearbyLevels[NearbyZone] || ins.GetZone() == l.Zone) &&
+		(level < nearbyLevels[NearbyCampus] || ins.GetCampus() == l.Campus)
+}
+
+// instanceHealthy reports whether ins is healthy and its circuit breaker is not open.
+func instanceHealthy(ins model.Instance) bool {
+	if !ins.IsHealthy() || ins.IsIsolated() {
+		return false
+	}
+	cbStatus := ins.GetCircuitBreakerStatus()
+	return cbStatus == nil || cbStatus.GetStatus() != model.Open
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	"github.com/polarismesh/polaris-go/pkg/model/local"
+	"github.com/polarismesh/polaris-go/pkg/model/pb"
+	namingpb "github.com/polarismesh/polaris-go/pkg/model/pb/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kitex-contrib/polaris/polaristest"
+)
+
+func newNearbyInstance(port int, region, zone, campus string, healthy bool) model.Instance {
+	return pb.NewInstanceInProto(&namingpb.Instance{
+		Id:      &wrappers.StringValue{Value: fmt.Sprint(port)},
+		Host:    &wrappers.StringValue{Value: "127.0.0.1"},
+		Port:    &wrappers.UInt32Value{Value: uint32(port)},
+		Healthy: &wrappers.BoolValue{Value: healthy},
+		Location: &namingpb.Location{
+			Region: &wrappers.StringValue{Value: region},
+			Zone:   &wrappers.StringValue{Value: zone},
+			Campus: &wrappers.StringValue{Value: campus},
+		},
+	}, &model.ServiceKey{Namespace: DefaultPolarisNamespace, Service: "nearby"}, local.NewInstanceLocalValue())
+}
+
+func ports(instances []model.Instance) []int {
+	var ports []int
+	for _, ins := range instances {
+		ports = append(ports, int(ins.GetPort()))
+	}
+	return ports
+}
+
+func TestNearbyFilter(t *testing.T) {
+	instances := []model.Instance{
+		newNearbyInstance(1, "south", "sz", "a", true),
+		newNearbyInstance(2, "south", "sz", "b", false),
+		newNearbyInstance(3, "south", "gz", "c", true),
+		newNearbyInstance(4, "north", "bj", "d", true),
+	}
+	location := Location{Region: "south", Zone: "sz", Campus: "b"}
+
+	filter := func(no NearbyOptions) ([]int, error) {
+		no.Enable = true
+		no.Location = location
+		require.Nil(t, no.validate())
+		filtered, err := no.filter("nearby", instances)
+		return ports(filtered), err
+	}
+	// the instance of the campus is unhealthy, only the healthy instances of the zone are kept
+	filtered, err := filter(NearbyOptions{MatchLevel: NearbyCampus})
+	require.Nil(t, err)
+	require.Equal(t, []int{1}, filtered)
+	filtered, err = filter(NearbyOptions{})
+	require.Nil(t, err)
+	require.Equal(t, []int{1}, filtered)
+	// half of the zone is unhealthy
+	filtered, err = filter(NearbyOptions{UnhealthyPercentToDegrade: 50})
+	require.Nil(t, err)
+	require.Equal(t, []int{1, 3}, filtered)
+	filtered, err = filter(NearbyOptions{UnhealthyPercentToDegrade: 60})
+	require.Nil(t, err)
+	require.Equal(t, []int{1}, filtered)
+
+	location = Location{Region: "west", Zone: "cd"}
+	filtered, err = filter(NearbyOptions{MaxMatchLevel: NearbyRegion})
+	require.Nil(t, err)
+	require.Equal(t, []int{1, 2, 3, 4}, filtered)
+	_, err = filter(NearbyOptions{MaxMatchLevel: NearbyRegion, Strict: true})
+	require.True(t, errors.Is(err, ErrNoRoutedInstance), err)
+	// the campus of the client is unknown
+	location = Location{Region: "north", Zone: "bj"}
+	filtered, err = filter(NearbyOptions{MatchLevel: NearbyCampus})
+	require.Nil(t, err)
+	require.Equal(t, []int{4}, filtered)
+
+	require.NotNil(t, NearbyOptions{Enable: true, MatchLevel: "city"}.validate())
+	require.NotNil(t, NearbyOptions{Enable: true, MatchLevel: NearbyRegion, MaxMatchLevel: NearbyZone}.validate())
+	require.NotNil(t, NearbyOptions{Enable: true, UnhealthyPercentToDegrade: 101}.validate())
+}
+
+func TestClientLocation(t *testing.T) {
+	for key, value := range map[string]string{EnvRegion: "env-region", EnvZone: "env-zone", EnvCampus: "env-campus"} {
+		defer os.Setenv(key, os.Getenv(key))
+		os.Setenv(key, value)
+	}
+	l := clientLocation(Location{Campus: "campus"}, map[string]string{ZoneTagKey: "metadata-zone"})
+	require.Equal(t, Location{Region: "env-region", Zone: "metadata-zone", Campus: "campus"}, l)
+}
+
+func TestPolarisPickerNearby(t *testing.T) {
+	svcName := "balancer-nearby"
+	testServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{
+		Host: "127.0.0.1", Port: 9061, Region: "south", Zone: "sz",
+	})
+	testServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{
+		Host: "127.0.0.1", Port: 9062, Region: "south", Zone: "gz",
+	})
+
+	o := ClientOptions{Nearby: NearbyOptions{Enable: true, Location: Location{Region: "south", Zone: "gz"}}}
+	rs, err := NewPolarisResolver(o, WithPolarisClient(testClient))
+	require.Nil(t, err)
+	lb, err := NewPolarisBalancer(WithPolarisClient(testClient))
+	require.Nil(t, err)
+	defer lb.Destroy()
+	result := resolveForBalancer(t, rs, svcName)
+
+	for i := 0; i < 10; i++ {
+		ins := lb.GetPicker(result).Next(newRPCInfoCtx(svcName, "Echo"), nil)
+		require.NotNil(t, ins)
+		require.Equal(t, "127.0.0.1:9062", ins.Address().String())
+	}
+}
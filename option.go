@@ -0,0 +1,250 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/polarismesh/polaris-go/pkg/config"
+)
+
+// Environment variables read by WithEnv.
+const (
+	EnvAddresses              = "POLARIS_ADDRESSES"
+	EnvConnectTimeout         = "POLARIS_CONNECT_TIMEOUT"
+	EnvMessageTimeout         = "POLARIS_MESSAGE_TIMEOUT"
+	EnvPersistDir             = "POLARIS_PERSIST_DIR"
+	EnvServiceRefreshInterval = "POLARIS_SERVICE_REFRESH_INTERVAL"
+	EnvStatReporterEnable     = "POLARIS_STAT_REPORTER_ENABLE"
+)
+
+// Option selects the polaris client used by the components of this package.
+type Option func(o *options)
+
+type options struct {
+	configFile string
+	client     *PolarisClient
+	config     config.Configuration
+	settings   []configSetting
+	err        error
+}
+
+// configSetting changes one item of the configuration, key identifies the change.
+type configSetting struct {
+	key   string
+	apply func(cfg config.Configuration)
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func (o *options) set(key string, apply func(cfg config.Configuration)) {
+	o.settings = append(o.settings, configSetting{key: key, apply: apply})
+}
+
+// configured reports whether the configuration is built in code rather than only loaded from a file.
+func (o *options) configured() bool {
+	return o.config != nil || len(o.settings) != 0
+}
+
+// sharedKey identifies the clients which can be shared by the components configured with the same options.
+func (o *options) sharedKey() string {
+	if !o.configured() {
+		return o.configFile
+	}
+	keys := []string{o.configFile, fmt.Sprintf("%p", o.config)}
+	for _, s := range o.settings {
+		keys = append(keys, s.key)
+	}
+	return strings.Join(keys, "\n")
+}
+
+// buildConfig builds the configuration of the SDK from the options.
+func (o *options) buildConfig() (config.Configuration, error) {
+	if o.err != nil {
+		return nil, o.err
+	}
+	var (
+		cfg config.Configuration
+		err error
+	)
+	switch {
+	case o.config != nil:
+		cfg = o.config
+	case len(o.configFile) != 0:
+		cfg, err = config.LoadConfigurationByFile(o.configFile)
+	case len(o.settings) != 0:
+		// polaris.yaml is optional when the configuration is built in code
+		cfg = config.NewDefaultConfigurationWithDomain()
+	default:
+		cfg, err = config.LoadConfigurationByDefaultFile()
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range o.settings {
+		s.apply(cfg)
+	}
+	return cfg, nil
+}
+
+// WithConfigFile uses the client shared by all the components configured with the same file.
+func WithConfigFile(configFile string) Option {
+	return func(o *options) {
+		o.configFile = configFile
+	}
+}
+
+// WithPolarisClient uses the given client, the component holds a reference on it until it is destroyed.
+// The configuration options are ignored.
+func WithPolarisClient(client *PolarisClient) Option {
+	return func(o *options) {
+		o.client = client
+	}
+}
+
+// WithConfiguration uses cfg instead of a configuration file, the other configuration options are applied on it.
+func WithConfiguration(cfg config.Configuration) Option {
+	return func(o *options) {
+		o.config = cfg
+	}
+}
+
+// WithAddresses sets the addresses of the polaris servers, in the format of host:port.
+func WithAddresses(addresses ...string) Option {
+	return func(o *options) {
+		o.set("addresses="+strings.Join(addresses, ","), func(cfg config.Configuration) {
+			cfg.GetGlobal().GetServerConnector().SetAddresses(addresses)
+		})
+	}
+}
+
+// WithConnectTimeout sets the timeout of connecting to the polaris servers.
+func WithConnectTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.set("connectTimeout="+timeout.String(), func(cfg config.Configuration) {
+			cfg.GetGlobal().GetServerConnector().SetConnectTimeout(timeout)
+		})
+	}
+}
+
+// WithMessageTimeout sets the timeout of the requests to the polaris servers.
+func WithMessageTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.set("messageTimeout="+timeout.String(), func(cfg config.Configuration) {
+			cfg.GetGlobal().GetServerConnector().SetMessageTimeout(timeout)
+		})
+	}
+}
+
+// WithCircuitBreakerChain sets the circuit breakers in use, circuit breaking is disabled when chain is empty.
+func WithCircuitBreakerChain(chain ...string) Option {
+	return func(o *options) {
+		o.set("circuitBreakerChain="+strings.Join(chain, ","), func(cfg config.Configuration) {
+			cfg.GetConsumer().GetCircuitBreaker().SetEnable(len(chain) != 0)
+			if len(chain) != 0 {
+				cfg.GetConsumer().GetCircuitBreaker().SetChain(chain)
+			}
+		})
+	}
+}
+
+// WithPersistDir sets the directory where the SDK persists its local cache.
+func WithPersistDir(dir string) Option {
+	return func(o *options) {
+		o.set("persistDir="+dir, func(cfg config.Configuration) {
+			cfg.GetConsumer().GetLocalCache().SetPersistDir(dir)
+		})
+	}
+}
+
+// WithServiceRefreshInterval sets how often the SDK refreshes the services in its local cache.
+func WithServiceRefreshInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.set("serviceRefreshInterval="+interval.String(), func(cfg config.Configuration) {
+			cfg.GetConsumer().GetLocalCache().SetServiceRefreshInterval(interval)
+		})
+	}
+}
+
+// WithStatReporter enables or disables the stat reporter, chain replaces the reporter plugins if not empty.
+func WithStatReporter(enable bool, chain ...string) Option {
+	return func(o *options) {
+		o.set(fmt.Sprintf("statReporter=%t:%s", enable, strings.Join(chain, ",")), func(cfg config.Configuration) {
+			cfg.GetGlobal().GetStatReporter().SetEnable(enable)
+			if len(chain) != 0 {
+				cfg.GetGlobal().GetStatReporter().SetChain(chain)
+			}
+		})
+	}
+}
+
+// WithEnv overlays the configuration with the environment variables which are set:
+// POLARIS_ADDRESSES (comma separated), POLARIS_CONNECT_TIMEOUT, POLARIS_MESSAGE_TIMEOUT,
+// POLARIS_PERSIST_DIR, POLARIS_SERVICE_REFRESH_INTERVAL and POLARIS_STAT_REPORTER_ENABLE.
+// It should be the last option, so that the environment takes precedence.
+func WithEnv() Option {
+	return func(o *options) {
+		if v, ok := os.LookupEnv(EnvAddresses); ok {
+			WithAddresses(strings.Split(v, ",")...)(o)
+		}
+		if v, ok := os.LookupEnv(EnvConnectTimeout); ok {
+			if d, ok := parseEnvDuration(o, EnvConnectTimeout, v); ok {
+				WithConnectTimeout(d)(o)
+			}
+		}
+		if v, ok := os.LookupEnv(EnvMessageTimeout); ok {
+			if d, ok := parseEnvDuration(o, EnvMessageTimeout, v); ok {
+				WithMessageTimeout(d)(o)
+			}
+		}
+		if v, ok := os.LookupEnv(EnvPersistDir); ok {
+			WithPersistDir(v)(o)
+		}
+		if v, ok := os.LookupEnv(EnvServiceRefreshInterval); ok {
+			if d, ok := parseEnvDuration(o, EnvServiceRefreshInterval, v); ok {
+				WithServiceRefreshInterval(d)(o)
+			}
+		}
+		if v, ok := os.LookupEnv(EnvStatReporterEnable); ok {
+			enable, err := strconv.ParseBool(v)
+			if err != nil {
+				o.err = fmt.Errorf("invalid %s %q: %w", EnvStatReporterEnable, v, err)
+				return
+			}
+			WithStatReporter(enable)(o)
+		}
+	}
+}
+
+func parseEnvDuration(o *options, name, value string) (time.Duration, bool) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		o.err = fmt.Errorf("invalid %s %q: %w", name, value, err)
+		return 0, false
+	}
+	return d, true
+}
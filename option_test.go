@@ -0,0 +1,98 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/kitex-contrib/polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolarisClientWithOptions(t *testing.T) {
+	svcName := "client-options"
+	testServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{Host: "127.0.0.1", Port: 9500})
+
+	dir, err := ioutil.TempDir("", "polaris-options")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	pc, err := NewPolarisClientWithOptions(
+		WithAddresses(testServer.Addr()),
+		WithConnectTimeout(100*time.Millisecond),
+		WithPersistDir(dir),
+		WithServiceRefreshInterval(polaristest.RefreshInterval),
+		WithStatReporter(false),
+		WithCircuitBreakerChain("errorCount"),
+	)
+	require.Nil(t, err)
+	defer pc.Destroy()
+
+	cfg := pc.SDKContext().GetConfig()
+	require.Equal(t, []string{testServer.Addr()}, cfg.GetGlobal().GetServerConnector().GetAddresses())
+	require.Equal(t, dir, cfg.GetConsumer().GetLocalCache().GetPersistDir())
+	require.Equal(t, []string{"errorCount"}, cfg.GetConsumer().GetCircuitBreaker().GetChain())
+	require.False(t, cfg.GetGlobal().GetStatReporter().IsEnable())
+
+	rs, err := NewPolarisResolver(ClientOptions{}, WithPolarisClient(pc))
+	require.Nil(t, err)
+	defer rs.Destroy()
+	desc := rs.Target(context.TODO(), rpcinfo.NewEndpointInfo(svcName, "", nil, nil))
+	result, err := rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 1)
+}
+
+func TestWithEnv(t *testing.T) {
+	setEnv := func(name, value string) {
+		old, ok := os.LookupEnv(name)
+		os.Setenv(name, value)
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(name, old)
+			} else {
+				os.Unsetenv(name)
+			}
+		})
+	}
+	setEnv(EnvAddresses, "127.0.0.1:8091,127.0.0.1:8092")
+	setEnv(EnvConnectTimeout, "300ms")
+	setEnv(EnvStatReporterEnable, "false")
+
+	// the environment takes precedence over the options before WithEnv
+	o := newOptions([]Option{WithAddresses("127.0.0.1:8090"), WithEnv()})
+	cfg, err := o.buildConfig()
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1:8091", "127.0.0.1:8092"}, cfg.GetGlobal().GetServerConnector().GetAddresses())
+	require.Equal(t, 300*time.Millisecond, cfg.GetGlobal().GetServerConnector().GetConnectTimeout())
+	require.False(t, cfg.GetGlobal().GetStatReporter().IsEnable())
+
+	// components configured alike share a client
+	require.Equal(t, o.sharedKey(), newOptions([]Option{WithAddresses("127.0.0.1:8090"), WithEnv()}).sharedKey())
+	require.NotEqual(t, o.sharedKey(), newOptions([]Option{WithEnv()}).sharedKey())
+
+	setEnv(EnvConnectTimeout, "soon")
+	_, err = newOptions([]Option{WithEnv()}).buildConfig()
+	require.NotNil(t, err)
+	_, err = NewPolarisResolver(ClientOptions{}, WithEnv())
+	require.NotNil(t, err)
+}
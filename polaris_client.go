@@ -0,0 +1,145 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/config"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+// PolarisClient is a reference counted polaris SDK context.
+// The creator and every component built on the client hold a reference,
+// the SDK context is destroyed once all of them are released.
+type PolarisClient struct {
+	sdkCtx      api.SDKContext
+	refs        int32
+	destroyOnce sync.Once
+
+	watchLock sync.Mutex
+	watchers  map[model.ServiceKey]*serviceWatcher
+}
+
+// NewPolarisClient creates a polaris client from configFile, or from the default polaris.yaml when configFile is empty.
+func NewPolarisClient(configFile ...string) (*PolarisClient, error) {
+	var (
+		cfg config.Configuration
+		err error
+	)
+
+	if len(configFile) != 0 {
+		cfg, err = config.LoadConfigurationByFile(configFile[0])
+	} else {
+		cfg, err = config.LoadConfigurationByDefaultFile()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return NewPolarisClientByConfig(cfg)
+}
+
+// NewPolarisClientWithOptions creates a polaris client from the configuration options,
+// WithPolarisClient is ignored.
+func NewPolarisClientWithOptions(opts ...Option) (*PolarisClient, error) {
+	cfg, err := newOptions(opts).buildConfig()
+	if err != nil {
+		return nil, err
+	}
+	return NewPolarisClientByConfig(cfg)
+}
+
+// NewPolarisClientByConfig creates a polaris client from the configuration.
+func NewPolarisClientByConfig(cfg config.Configuration) (*PolarisClient, error) {
+	sdkCtx, err := api.InitContextByConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &PolarisClient{sdkCtx: sdkCtx, refs: 1}, nil
+}
+
+// SDKContext returns the polaris SDK context of the client.
+func (c *PolarisClient) SDKContext() api.SDKContext {
+	return c.sdkCtx
+}
+
+// Destroy releases the reference held by the creator of the client,
+// the SDK context is destroyed when no component uses it anymore.
+func (c *PolarisClient) Destroy() {
+	c.destroyOnce.Do(c.release)
+}
+
+// retain takes a reference on behalf of a component.
+func (c *PolarisClient) retain() error {
+	for {
+		refs := atomic.LoadInt32(&c.refs)
+		if refs <= 0 {
+			return fmt.Errorf("polaris client has been destroyed")
+		}
+		if atomic.CompareAndSwapInt32(&c.refs, refs, refs+1) {
+			return nil
+		}
+	}
+}
+
+// release drops a reference taken by retain or by the creator.
+func (c *PolarisClient) release() {
+	if atomic.AddInt32(&c.refs, -1) == 0 {
+		c.sdkCtx.Destroy()
+	}
+}
+
+// clientRef is the reference a component holds on a PolarisClient.
+type clientRef struct {
+	client      *PolarisClient
+	releaseOnce sync.Once
+}
+
+// getClient returns the polaris client selected by opts without taking a reference.
+func getClient(opts []Option) (*PolarisClient, error) {
+	o := newOptions(opts)
+	if o.client != nil {
+		return o.client, nil
+	}
+	return getSharedClient(o)
+}
+
+// newClientRef returns a reference to the polaris client selected by opts.
+func newClientRef(opts []Option) (*clientRef, error) {
+	client, err := getClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = client.retain(); err != nil {
+		return nil, err
+	}
+	return &clientRef{client: client}, nil
+}
+
+// SDKContext returns the polaris SDK context of the referenced client.
+func (r *clientRef) SDKContext() api.SDKContext {
+	return r.client.sdkCtx
+}
+
+// Destroy releases the reference, it is safe to call more than once.
+func (r *clientRef) Destroy() {
+	r.releaseOnce.Do(r.client.release)
+}
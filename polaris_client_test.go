@@ -0,0 +1,110 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kitex-contrib/polaris/polaristest"
+)
+
+func TestPolarisClientPerCluster(t *testing.T) {
+	otherServer, err := polaristest.NewServer()
+	require.Nil(t, err)
+	defer otherServer.Close()
+	otherClient, err := NewPolarisClient(otherServer.ConfigFile())
+	require.Nil(t, err)
+	defer otherClient.Destroy()
+
+	svcName := "client-cluster"
+	testServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{Host: "127.0.0.1", Port: 9100})
+	otherServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{Host: "127.0.0.1", Port: 9200})
+
+	rs, err := NewPolarisResolver(ClientOptions{}, WithPolarisClient(otherClient))
+	require.Nil(t, err)
+	defer rs.Destroy()
+	desc := rs.Target(context.TODO(), rpcinfo.NewEndpointInfo(svcName, "", nil, nil))
+	result, err := rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 1)
+	require.Equal(t, "127.0.0.1:9200", result.Instances[0].Address().String())
+
+	rs, err = NewPolarisResolver(ClientOptions{}, WithPolarisClient(testClient))
+	require.Nil(t, err)
+	defer rs.Destroy()
+	result, err = rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 1)
+	require.Equal(t, "127.0.0.1:9100", result.Instances[0].Address().String())
+}
+
+func TestPolarisClientDestroy(t *testing.T) {
+	client, err := NewPolarisClient(testServer.ConfigFile())
+	require.Nil(t, err)
+	rs, err := NewPolarisResolver(ClientOptions{}, WithPolarisClient(client))
+	require.Nil(t, err)
+
+	// the resolver still holds a reference
+	client.Destroy()
+	client.Destroy()
+	require.False(t, client.SDKContext().IsDestroyed())
+
+	rs.Destroy()
+	rs.Destroy()
+	require.True(t, client.SDKContext().IsDestroyed())
+
+	_, err = NewPolarisBalancer(WithPolarisClient(client))
+	require.NotNil(t, err)
+}
+
+func TestGetPolarisConfig(t *testing.T) {
+	otherServer, err := polaristest.NewServer()
+	require.Nil(t, err)
+	defer otherServer.Close()
+
+	sdkCtx, err := GetPolarisConfig(testServer.ConfigFile())
+	require.Nil(t, err)
+	sameCtx, err := GetPolarisConfig(testServer.ConfigFile())
+	require.Nil(t, err)
+	require.Equal(t, sdkCtx, sameCtx)
+
+	otherCtx, err := GetPolarisConfig(otherServer.ConfigFile())
+	require.Nil(t, err)
+	require.NotEqual(t, sdkCtx, otherCtx)
+}
+
+func TestMiddlewaresHoldClient(t *testing.T) {
+	client, err := NewPolarisClient(testServer.ConfigFile())
+	require.Nil(t, err)
+	next := func(ctx context.Context, req, resp interface{}) error { return nil }
+	NewUpdateServiceCallResultMW(WithPolarisClient(client))(next)
+	NewRateLimitMW(RateLimitOptions{}, WithPolarisClient(client))(next)
+
+	// the middlewares still hold their references
+	client.Destroy()
+	require.False(t, client.SDKContext().IsDestroyed())
+	client.release()
+	client.release()
+	require.True(t, client.SDKContext().IsDestroyed())
+
+	err = NewUpdateServiceCallResultMW(WithPolarisClient(client))(next)(context.TODO(), nil, nil)
+	require.NotNil(t, err)
+}
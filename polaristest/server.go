@@ -0,0 +1,495 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package polaristest provides an in-process stand-in for the Polaris naming,
+// heartbeat, routing and rate limit APIs, so that components built on
+// polaris-go can be tested without a real Polaris server.
+package polaristest
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	namingpb "github.com/polarismesh/polaris-go/pkg/model/pb/v1"
+	"google.golang.org/grpc"
+)
+
+const (
+	// RefreshInterval is the service refresh interval written to the generated configuration.
+	RefreshInterval = 100 * time.Millisecond
+	// SyncTimeout bounds how long the SDK takes to observe a change made on the server,
+	// polaris-go adds up to 3 seconds of jitter to every refresh task.
+	SyncTimeout = RefreshInterval + 4*time.Second
+)
+
+const (
+	systemNamespace    = "Polaris"
+	discoverService    = "polaris.discover"
+	healthCheckService = "polaris.healthcheck"
+)
+
+const configTemplate = `global:
+  serverConnector:
+    addresses:
+      - %s
+    connectTimeout: 100ms
+  statReporter:
+    enable: false
+consumer:
+  localCache:
+    serviceRefreshInterval: %s
+    persistDir: %s
+`
+
+// Instance describes a service instance held by the fake server.
+type Instance struct {
+	ID        string
+	Host      string
+	Port      int
+	Protocol  string
+	Version   string
+	Weight    int
+	Priority  int
+	Region    string
+	Zone      string
+	Campus    string
+	Unhealthy bool
+	Isolate   bool
+	Metadata  map[string]string
+	// TTL is the heartbeat TTL in seconds of a registered instance, 0 if it has no heartbeat health check.
+	TTL int
+}
+
+type serviceKey struct {
+	namespace string
+	service   string
+}
+
+type service struct {
+	revision   uint64
+	instances  []*namingpb.Instance
+	heartbeats map[string]int
+	routing    *namingpb.Routing
+	rateLimit  *namingpb.RateLimit
+}
+
+// Server is an in-memory Polaris server listening on a local port.
+type Server struct {
+	lis        net.Listener
+	grpcServer *grpc.Server
+	dir        string
+	configFile string
+
+	lock     sync.RWMutex
+	services map[serviceKey]*service
+}
+
+// NewServer starts a fake Polaris server on a random local port.
+func NewServer() (*Server, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir("", "polaristest")
+	if err != nil {
+		lis.Close()
+		return nil, err
+	}
+	s := &Server{
+		lis:        lis,
+		grpcServer: grpc.NewServer(),
+		dir:        dir,
+		configFile: filepath.Join(dir, "polaris.yaml"),
+		services:   make(map[serviceKey]*service),
+	}
+	content := fmt.Sprintf(configTemplate, s.Addr(), RefreshInterval, filepath.Join(dir, "backup"))
+	if err = ioutil.WriteFile(s.configFile, []byte(content), 0o644); err != nil {
+		s.Close()
+		return nil, err
+	}
+	// Serve the system services like a real server does, so the SDK connects
+	// to this server without waiting for the discover cluster to be ready.
+	host, port, _ := net.SplitHostPort(s.Addr())
+	portNum, _ := strconv.Atoi(port)
+	for _, name := range []string{discoverService, healthCheckService} {
+		s.AddInstance(systemNamespace, name, Instance{Host: host, Port: portNum, Protocol: "grpc"})
+	}
+	namingpb.RegisterPolarisGRPCServer(s.grpcServer, s)
+	go s.grpcServer.Serve(lis)
+	return s, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() string {
+	return s.lis.Addr().String()
+}
+
+// ConfigFile returns the path of a polaris.yaml pointing at this server,
+// which can be passed to GetPolarisConfig and the constructors.
+func (s *Server) ConfigFile() string {
+	return s.configFile
+}
+
+// Close stops the server and removes the generated configuration.
+func (s *Server) Close() {
+	s.grpcServer.Stop()
+	os.RemoveAll(s.dir)
+}
+
+// AddInstance adds an instance to the given service, creating the service if needed.
+// It returns the id of the instance.
+func (s *Server) AddInstance(namespace, serviceName string, ins Instance) string {
+	pbIns := &namingpb.Instance{
+		Namespace: wrapString(namespace),
+		Service:   wrapString(serviceName),
+		Host:      wrapString(ins.Host),
+		Port:      &wrappers.UInt32Value{Value: uint32(ins.Port)},
+		Protocol:  wrapString(ins.Protocol),
+		Version:   wrapString(ins.Version),
+		Healthy:   &wrappers.BoolValue{Value: !ins.Unhealthy},
+		Isolate:   &wrappers.BoolValue{Value: ins.Isolate},
+		Metadata:  ins.Metadata,
+		Priority:  &wrappers.UInt32Value{Value: uint32(ins.Priority)},
+		Location: &namingpb.Location{
+			Region: wrapString(ins.Region),
+			Zone:   wrapString(ins.Zone),
+			Campus: wrapString(ins.Campus),
+		},
+	}
+	if ins.Weight > 0 {
+		pbIns.Weight = &wrappers.UInt32Value{Value: uint32(ins.Weight)}
+	}
+	if len(ins.ID) != 0 {
+		pbIns.Id = wrapString(ins.ID)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	svc := s.getOrCreateService(namespace, serviceName)
+	svc.put(fillInstance(pbIns))
+	return pbIns.GetId().GetValue()
+}
+
+// RemoveInstance removes the instance with the given address, it reports whether
+// the instance existed.
+func (s *Server) RemoveInstance(namespace, serviceName, host string, port int) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	svc, ok := s.services[serviceKey{namespace: namespace, service: serviceName}]
+	if !ok {
+		return false
+	}
+	return svc.remove(host, uint32(port))
+}
+
+// Instances returns the instances currently registered for the given service.
+func (s *Server) Instances(namespace, serviceName string) []Instance {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	svc, ok := s.services[serviceKey{namespace: namespace, service: serviceName}]
+	if !ok {
+		return nil
+	}
+	result := make([]Instance, 0, len(svc.instances))
+	for _, ins := range svc.instances {
+		result = append(result, Instance{
+			ID:        ins.GetId().GetValue(),
+			Host:      ins.GetHost().GetValue(),
+			Port:      int(ins.GetPort().GetValue()),
+			Protocol:  ins.GetProtocol().GetValue(),
+			Version:   ins.GetVersion().GetValue(),
+			Weight:    int(ins.GetWeight().GetValue()),
+			Priority:  int(ins.GetPriority().GetValue()),
+			Region:    ins.GetLocation().GetRegion().GetValue(),
+			Zone:      ins.GetLocation().GetZone().GetValue(),
+			Campus:    ins.GetLocation().GetCampus().GetValue(),
+			Unhealthy: !ins.GetHealthy().GetValue(),
+			Isolate:   ins.GetIsolate().GetValue(),
+			Metadata:  ins.GetMetadata(),
+			TTL:       int(ins.GetHealthCheck().GetHeartbeat().GetTtl().GetValue()),
+		})
+	}
+	return result
+}
+
+// Heartbeats returns how many heartbeats the instance with the given address has sent.
+func (s *Server) Heartbeats(namespace, serviceName, host string, port int) int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	svc, ok := s.services[serviceKey{namespace: namespace, service: serviceName}]
+	if !ok {
+		return 0
+	}
+	i := svc.indexOf(host, uint32(port))
+	if i < 0 {
+		return 0
+	}
+	return svc.heartbeats[svc.instances[i].GetId().GetValue()]
+}
+
+// SetRouting sets the routing rule of the given service, a nil rule clears it.
+func (s *Server) SetRouting(namespace, serviceName string, routing *namingpb.Routing) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	svc := s.getOrCreateService(namespace, serviceName)
+	svc.revision++
+	if routing != nil {
+		routing.Namespace = wrapString(namespace)
+		routing.Service = wrapString(serviceName)
+		routing.Revision = wrapString(strconv.FormatUint(svc.revision, 10))
+	}
+	svc.routing = routing
+}
+
+// SetRateLimit sets the rate limit rules of the given service, no rules clears them.
+func (s *Server) SetRateLimit(namespace, serviceName string, rules ...*namingpb.Rule) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	svc := s.getOrCreateService(namespace, serviceName)
+	svc.revision++
+	if len(rules) == 0 {
+		svc.rateLimit = nil
+		return
+	}
+	revision := strconv.FormatUint(svc.revision, 10)
+	for i, rule := range rules {
+		rule.Namespace = wrapString(namespace)
+		rule.Service = wrapString(serviceName)
+		if rule.Id == nil {
+			rule.Id = wrapString(fmt.Sprintf("%s-%d", serviceName, i))
+		}
+		// the SDK keys the windows of the rules by their revisions
+		rule.Revision = wrapString(fmt.Sprintf("%s-%d", revision, i))
+	}
+	svc.rateLimit = &namingpb.RateLimit{Rules: rules, Revision: wrapString(revision)}
+}
+
+// QPSRule returns a local rate limit rule admitting maxAmount requests per interval.
+func QPSRule(maxAmount uint32, interval time.Duration) *namingpb.Rule {
+	return &namingpb.Rule{
+		Type:   namingpb.Rule_LOCAL,
+		Action: wrapString("reject"),
+		Amounts: []*namingpb.Amount{{
+			MaxAmount:     &wrappers.UInt32Value{Value: maxAmount},
+			ValidDuration: &duration.Duration{Seconds: int64(interval / time.Second), Nanos: int32(interval % time.Second)},
+		}},
+	}
+}
+
+// MatchLabels makes rule only apply to the requests with all the given labels, it returns rule.
+func MatchLabels(rule *namingpb.Rule, labels map[string]string) *namingpb.Rule {
+	if rule.Labels == nil {
+		rule.Labels = make(map[string]*namingpb.MatchString, len(labels))
+	}
+	for k, v := range labels {
+		rule.Labels[k] = &namingpb.MatchString{Type: namingpb.MatchString_EXACT, Value: wrapString(v)}
+	}
+	return rule
+}
+
+// ReportClient implements the PolarisGRPCServer interface.
+func (s *Server) ReportClient(ctx context.Context, client *namingpb.Client) (*namingpb.Response, error) {
+	return &namingpb.Response{
+		Code:   &wrappers.UInt32Value{Value: namingpb.ExecuteSuccess},
+		Client: client,
+	}, nil
+}
+
+// RegisterInstance implements the PolarisGRPCServer interface.
+func (s *Server) RegisterInstance(ctx context.Context, ins *namingpb.Instance) (*namingpb.Response, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	svc := s.getOrCreateService(ins.GetNamespace().GetValue(), ins.GetService().GetValue())
+	code := uint32(namingpb.ExecuteSuccess)
+	if i := svc.indexOf(ins.GetHost().GetValue(), ins.GetPort().GetValue()); i >= 0 {
+		// like Polaris, registering an existing instance again updates it
+		ins.Id = svc.instances[i].Id
+		code = namingpb.ExistedResource
+	}
+	ins = fillInstance(ins)
+	svc.put(ins)
+	return response(code, ins), nil
+}
+
+// DeregisterInstance implements the PolarisGRPCServer interface.
+func (s *Server) DeregisterInstance(ctx context.Context, ins *namingpb.Instance) (*namingpb.Response, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	svc, ok := s.services[keyOf(ins)]
+	if !ok || !svc.remove(ins.GetHost().GetValue(), ins.GetPort().GetValue()) {
+		return response(namingpb.NotFoundResource, nil), nil
+	}
+	return response(namingpb.ExecuteSuccess, ins), nil
+}
+
+// Heartbeat implements the PolarisGRPCServer interface.
+func (s *Server) Heartbeat(ctx context.Context, ins *namingpb.Instance) (*namingpb.Response, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	svc, ok := s.services[keyOf(ins)]
+	if !ok {
+		return response(namingpb.NotFoundResource, nil), nil
+	}
+	i := svc.indexOf(ins.GetHost().GetValue(), ins.GetPort().GetValue())
+	if i < 0 {
+		return response(namingpb.NotFoundResource, nil), nil
+	}
+	svc.heartbeats[svc.instances[i].GetId().GetValue()]++
+	return response(namingpb.ExecuteSuccess, svc.instances[i]), nil
+}
+
+// Discover implements the PolarisGRPCServer interface.
+func (s *Server) Discover(stream namingpb.PolarisGRPC_DiscoverServer) error {
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err = stream.Send(s.discover(req)); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) discover(req *namingpb.DiscoverRequest) *namingpb.DiscoverResponse {
+	resp := &namingpb.DiscoverResponse{
+		Code:    &wrappers.UInt32Value{Value: namingpb.ExecuteSuccess},
+		Type:    namingpb.DiscoverResponse_DiscoverResponseType(req.GetType()),
+		Service: req.GetService(),
+	}
+	key := serviceKey{
+		namespace: req.GetService().GetNamespace().GetValue(),
+		service:   req.GetService().GetName().GetValue(),
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	svc, ok := s.services[key]
+	if !ok {
+		resp.Code = &wrappers.UInt32Value{Value: namingpb.NotFoundResource}
+		resp.Info = wrapString(fmt.Sprintf("service %s:%s not found", key.namespace, key.service))
+		return resp
+	}
+	resp.Service = &namingpb.Service{
+		Namespace: wrapString(key.namespace),
+		Name:      wrapString(key.service),
+		Revision:  wrapString(strconv.FormatUint(svc.revision, 10)),
+	}
+	switch req.GetType() {
+	case namingpb.DiscoverRequest_INSTANCE:
+		resp.Instances = append(resp.Instances, svc.instances...)
+	case namingpb.DiscoverRequest_ROUTING:
+		resp.Routing = svc.routing
+	case namingpb.DiscoverRequest_RATE_LIMIT:
+		resp.RateLimit = svc.rateLimit
+	}
+	return resp
+}
+
+func (s *Server) getOrCreateService(namespace, serviceName string) *service {
+	key := serviceKey{namespace: namespace, service: serviceName}
+	svc, ok := s.services[key]
+	if !ok {
+		svc = &service{heartbeats: make(map[string]int)}
+		s.services[key] = svc
+	}
+	return svc
+}
+
+func (svc *service) indexOf(host string, port uint32) int {
+	for i, ins := range svc.instances {
+		if ins.GetHost().GetValue() == host && ins.GetPort().GetValue() == port {
+			return i
+		}
+	}
+	return -1
+}
+
+// put adds ins or replaces the instance with the same address.
+func (svc *service) put(ins *namingpb.Instance) {
+	svc.revision++
+	ins.Revision = wrapString(strconv.FormatUint(svc.revision, 10))
+	if i := svc.indexOf(ins.GetHost().GetValue(), ins.GetPort().GetValue()); i >= 0 {
+		svc.instances[i] = ins
+		return
+	}
+	svc.instances = append(svc.instances, ins)
+}
+
+func (svc *service) remove(host string, port uint32) bool {
+	i := svc.indexOf(host, port)
+	if i < 0 {
+		return false
+	}
+	delete(svc.heartbeats, svc.instances[i].GetId().GetValue())
+	svc.instances = append(svc.instances[:i], svc.instances[i+1:]...)
+	svc.revision++
+	return true
+}
+
+// fillInstance sets the fields a real server fills in on registration.
+func fillInstance(ins *namingpb.Instance) *namingpb.Instance {
+	if ins.GetId().GetValue() == "" {
+		ins.Id = wrapString(instanceID(ins.GetNamespace().GetValue(), ins.GetService().GetValue(),
+			ins.GetHost().GetValue(), ins.GetPort().GetValue()))
+	}
+	if ins.Weight == nil {
+		ins.Weight = &wrappers.UInt32Value{Value: 100}
+	}
+	if ins.Healthy == nil {
+		ins.Healthy = &wrappers.BoolValue{Value: true}
+	}
+	if ins.Isolate == nil {
+		ins.Isolate = &wrappers.BoolValue{Value: false}
+	}
+	ins.ServiceToken = nil
+	return ins
+}
+
+func instanceID(namespace, serviceName, host string, port uint32) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s##%s##%s##%d", namespace, serviceName, host, port)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+func keyOf(ins *namingpb.Instance) serviceKey {
+	return serviceKey{namespace: ins.GetNamespace().GetValue(), service: ins.GetService().GetValue()}
+}
+
+func response(code uint32, ins *namingpb.Instance) *namingpb.Response {
+	return &namingpb.Response{
+		Code:     &wrappers.UInt32Value{Value: code},
+		Instance: ins,
+	}
+}
+
+func wrapString(value string) *wrappers.StringValue {
+	return &wrappers.StringValue{Value: value}
+}
@@ -18,27 +18,125 @@ package polaris
 
 import (
 	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/polarismesh/polaris-go/api"
 	"github.com/polarismesh/polaris-go/pkg/log"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	namingpb "github.com/polarismesh/polaris-go/pkg/model/pb/v1"
 )
 
+// ruleRefreshInterval is how often Acquire looks for a new rate limit rule.
+var ruleRefreshInterval = time.Second
+
+// LimiterMode is how a limiter waits for the quota and handles the errors of polaris.
+type LimiterMode int
+
+const (
+	// LimiterModeQueue waits for the quota until the deadline of the request context,
+	// which suits the uniform rate rules, and rejects the requests when polaris fails.
+	LimiterModeQueue LimiterMode = iota
+	// LimiterModeFailFast never waits for the quota and rejects the requests when polaris fails.
+	LimiterModeFailFast
+	// LimiterModeFailOpen never waits for the quota and admits the requests when polaris fails.
+	LimiterModeFailOpen
+)
+
+// RejectReason is why a limiter rejected a request.
+type RejectReason string
+
+const (
+	// RejectLimited means the rate limit rule denied the quota.
+	RejectLimited RejectReason = "limited"
+	// RejectNotReady means the quota was not available at once in LimiterModeFailFast.
+	RejectNotReady RejectReason = "not_ready"
+	// RejectTimeout means the request context was done before the quota was available in LimiterModeQueue.
+	RejectTimeout RejectReason = "timeout"
+	// RejectError means polaris failed to allocate the quota.
+	RejectError RejectReason = "error"
+)
+
+// LimiterEvent is the decision of a limiter on a request.
+type LimiterEvent struct {
+	Namespace string
+	Service   string
+	// Reason is why the request is rejected, empty when it is admitted.
+	Reason RejectReason
+	// Err is the error of polaris, the request is admitted in spite of it in LimiterModeFailOpen.
+	Err error
+}
+
+// LimiterObserver is called with the decision on every request, such as to export the rejections as metrics.
+// It is called on the request path and must not block.
+type LimiterObserver func(event LimiterEvent)
+
 // qpsLimiter implements the RateLimiter interface.
 type qpsLimiter struct {
+	// the counters are updated atomically, they come first to be 64-bit aligned
+	refreshed int64 // unix nano
+	window    int64 // unix nano
+	current   int64
+	allowed   uint64
+	failOpen  uint64
+	rejected  map[RejectReason]*uint64
+
+	client    *clientRef
 	namespace string
 	svcName   string
+	mode      LimiterMode
+	observer  LimiterObserver
 	limitAPI  api.LimitAPI
+
+	// lock serializes the refreshes of rule
+	lock sync.Mutex
+	rule atomic.Value // limiterRule
+}
+
+// limiterRule is the limit of the active rate limit rule of the service.
+type limiterRule struct {
+	revision string
+	max      int
+	interval time.Duration
+}
+
+// LimiterMetrics is the state of a limiter, it can be published with expvar.
+type LimiterMetrics struct {
+	Namespace string `json:"namespace"`
+	Service   string `json:"service"`
+	// MaxQPS is the effective limit of the active rule, zero if there is no rule.
+	MaxQPS float64 `json:"max_qps"`
+	// Max is the number of requests admitted in Interval.
+	Max      int           `json:"max"`
+	Interval time.Duration `json:"interval"`
+	// Current is the number of requests admitted by this limiter in the current window.
+	Current int    `json:"current"`
+	Allowed uint64 `json:"allowed"`
+	// FailOpen is the number of requests admitted because polaris failed, it is included in Allowed.
+	FailOpen uint64 `json:"fail_open"`
+	// Rejected is the number of requests rejected by reason.
+	Rejected map[RejectReason]uint64 `json:"rejected"`
 }
 
 // NewQPSLimiter creates a new qpsLimiter.
-func NewQPSLimiter(configFile ...string) (*qpsLimiter, error) {
-	sdkCtx, err := GetPolarisConfig(configFile...)
+func NewQPSLimiter(opts ...Option) (*qpsLimiter, error) {
+	client, err := newClientRef(opts)
 	if err != nil {
 		return nil, err
 	}
 
-	return &qpsLimiter{limitAPI: api.NewLimitAPIByContext(sdkCtx)}, nil
+	p := &qpsLimiter{
+		client:   client,
+		limitAPI: api.NewLimitAPIByContext(client.SDKContext()),
+		rejected: make(map[RejectReason]*uint64),
+	}
+	for _, reason := range []RejectReason{RejectLimited, RejectNotReady, RejectTimeout, RejectError} {
+		p.rejected[reason] = new(uint64)
+	}
+	p.rule.Store(limiterRule{})
+	return p, nil
 }
 
 // WithNamespace sets the namespace of the service.
@@ -53,19 +151,233 @@ func (p *qpsLimiter) WithServiceName(svcName string) *qpsLimiter {
 	return p
 }
 
+// WithMode sets the mode of the limiter, LimiterModeQueue by default.
+func (p *qpsLimiter) WithMode(mode LimiterMode) *qpsLimiter {
+	p.mode = mode
+	return p
+}
+
+// WithObserver sets the function called with the decision on every request, such as to export metrics.
+func (p *qpsLimiter) WithObserver(observer LimiterObserver) *qpsLimiter {
+	p.observer = observer
+	return p
+}
+
+// Destroy releases the polaris client used by the limiter.
+func (p *qpsLimiter) Destroy() {
+	p.client.Destroy()
+}
+
 func (p *qpsLimiter) Acquire(ctx context.Context) bool {
 	quotaReq := api.NewQuotaRequest()
 	quotaReq.SetNamespace(p.namespace)
 	quotaReq.SetService(p.svcName)
-	future, err := p.limitAPI.GetQuota(quotaReq)
-	if nil != err {
+	reason, err := acquireQuota(ctx, p.limitAPI, quotaReq, p.mode)
+	if err != nil {
 		log.GetBaseLogger().Errorf("fail to do GetQuota, err is %v", err)
-		return false
 	}
-	rsp := future.Get()
-	return rsp.Code == api.QuotaResultOk
+	p.record(reason, err != nil)
+	if p.observer != nil {
+		p.observer(LimiterEvent{Namespace: p.namespace, Service: p.svcName, Reason: reason, Err: err})
+	}
+	return len(reason) == 0
+}
+
+// acquireQuota requests the quota in mode, it returns why the request is rejected or an empty reason if it is admitted.
+func acquireQuota(ctx context.Context, limitAPI api.LimitAPI, quotaReq api.QuotaRequest, mode LimiterMode) (RejectReason, error) {
+	future, err := limitAPI.GetQuota(quotaReq)
+	if err != nil {
+		if mode == LimiterModeFailOpen {
+			return "", err
+		}
+		return RejectError, err
+	}
+	select {
+	case <-future.Done():
+	default:
+		if mode != LimiterModeQueue {
+			// gives back the quota the future may still be allocated
+			future.Release()
+			return RejectNotReady, nil
+		}
+		select {
+		case <-future.Done():
+		case <-ctx.Done():
+			future.Release()
+			return RejectTimeout, nil
+		}
+	}
+	if future.Get().Code != api.QuotaResultOk {
+		return RejectLimited, nil
+	}
+	return "", nil
 }
 
+// Status returns the limit of the active rate limit rule and the requests admitted by the limiter in the current window,
+// max is zero when the service has no rule.
 func (p *qpsLimiter) Status(ctx context.Context) (max, current int, interval time.Duration) {
-	panic("not implemented")
+	now := time.Now()
+	rule := p.refreshRule(now)
+	return rule.max, p.currentOf(rule, now), rule.interval
 }
+
+// Metrics returns the state of the limiter.
+func (p *qpsLimiter) Metrics() LimiterMetrics {
+	now := time.Now()
+	rule := p.refreshRule(now)
+	m := LimiterMetrics{
+		Namespace: p.namespace,
+		Service:   p.svcName,
+		Max:       rule.max,
+		Interval:  rule.interval,
+		Current:   p.currentOf(rule, now),
+		Allowed:   atomic.LoadUint64(&p.allowed),
+		FailOpen:  atomic.LoadUint64(&p.failOpen),
+		Rejected:  make(map[RejectReason]uint64, len(p.rejected)),
+	}
+	for reason, n := range p.rejected {
+		if v := atomic.LoadUint64(n); v != 0 {
+			m.Rejected[reason] = v
+		}
+	}
+	if rule.interval > 0 {
+		m.MaxQPS = float64(rule.max) / rule.interval.Seconds()
+	}
+	return m
+}
+
+func (p *qpsLimiter) record(reason RejectReason, failed bool) {
+	if len(reason) != 0 {
+		if n, ok := p.rejected[reason]; ok {
+			atomic.AddUint64(n, 1)
+		}
+		return
+	}
+	atomic.AddUint64(&p.allowed, 1)
+	if failed {
+		atomic.AddUint64(&p.failOpen, 1)
+	}
+	now := time.Now()
+	rule := p.rule.Load().(limiterRule)
+	// a single request refreshes the rule when it is due
+	if refreshed := atomic.LoadInt64(&p.refreshed); now.UnixNano()-refreshed >= int64(ruleRefreshInterval) &&
+		atomic.CompareAndSwapInt64(&p.refreshed, refreshed, now.UnixNano()) {
+		rule = p.refreshRule(now)
+	}
+	window := windowOf(rule, now)
+	if prev := atomic.LoadInt64(&p.window); prev != window && atomic.CompareAndSwapInt64(&p.window, prev, window) {
+		atomic.StoreInt64(&p.current, 0)
+	}
+	atomic.AddInt64(&p.current, 1)
+}
+
+// currentOf returns the requests admitted in the window of now.
+func (p *qpsLimiter) currentOf(rule limiterRule, now time.Time) int {
+	if atomic.LoadInt64(&p.window) != windowOf(rule, now) {
+		return 0
+	}
+	return int(atomic.LoadInt64(&p.current))
+}
+
+// windowOf returns the start of the window of now in unix nano.
+func windowOf(rule limiterRule, now time.Time) int64 {
+	interval := rule.interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return now.Truncate(interval).UnixNano()
+}
+
+// refreshRule loads the active rule from the local cache of the SDK and returns it,
+// the window restarts when the rule changes.
+func (p *qpsLimiter) refreshRule(now time.Time) limiterRule {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	atomic.StoreInt64(&p.refreshed, now.UnixNano())
+	current := p.rule.Load().(limiterRule)
+	// the rule is loaded by the first quota request, the query only reads the cache of the SDK then
+	query := &rateLimitRuleQuery{service: model.ServiceKey{Namespace: p.namespace, Service: p.svcName}}
+	query.trigger.EnableDstRateLimit = true
+	query.param.Timeout = p.client.SDKContext().GetConfig().GetGlobal().GetAPI().GetTimeout()
+	if err := p.client.SDKContext().GetEngine().SyncGetResources(query); err != nil {
+		log.GetBaseLogger().Errorf("fail to get the rate limit rule of %s, err is %v", p.svcName, err)
+		return current
+	}
+	svcRule := query.rule
+	if svcRule == nil || !svcRule.IsInitialized() || svcRule.GetRevision() == current.revision {
+		return current
+	}
+	rule := limiterRule{revision: svcRule.GetRevision()}
+	if rateLimit, ok := svcRule.GetValue().(*namingpb.RateLimit); ok {
+		rule.max, rule.interval = activeLimit(rateLimit)
+	}
+	if rule.max != current.max || rule.interval != current.interval {
+		atomic.StoreInt64(&p.window, 0)
+		atomic.StoreInt64(&p.current, 0)
+	}
+	p.rule.Store(rule)
+	return rule
+}
+
+// activeLimit returns the strictest amount of the enabled rule applying to all the requests with the highest priority,
+// the rules are ordered as polaris does, by priority, the smallest value first, then by id.
+func activeLimit(rateLimit *namingpb.RateLimit) (max int, interval time.Duration) {
+	rules := append([]*namingpb.Rule(nil), rateLimit.GetRules()...)
+	sort.SliceStable(rules, func(i, j int) bool {
+		pi, pj := rules[i].GetPriority().GetValue(), rules[j].GetPriority().GetValue()
+		if pi != pj {
+			return pi < pj
+		}
+		return rules[i].GetId().GetValue() < rules[j].GetId().GetValue()
+	})
+	for _, rule := range rules {
+		if rule.GetDisable().GetValue() || !matchAllLabels(rule) {
+			continue
+		}
+		for _, amount := range rule.GetAmounts() {
+			d := amount.GetValidDuration()
+			amountInterval := time.Duration(d.GetSeconds())*time.Second + time.Duration(d.GetNanos())
+			if amountInterval <= 0 {
+				continue
+			}
+			amountMax := int(amount.GetMaxAmount().GetValue())
+			// compare amountMax/amountInterval with max/interval
+			if interval == 0 || float64(amountMax)/amountInterval.Seconds() < float64(max)/interval.Seconds() {
+				max, interval = amountMax, amountInterval
+			}
+		}
+		return max, interval
+	}
+	return 0, 0
+}
+
+func matchAllLabels(rule *namingpb.Rule) bool {
+	for key, value := range rule.GetLabels() {
+		if key != "*" && value.GetValue().GetValue() != "*" {
+			return false
+		}
+	}
+	return true
+}
+
+var _ model.CacheValueQuery = (*rateLimitRuleQuery)(nil)
+
+// rateLimitRuleQuery gets the rate limit rule of a service from the engine of the SDK.
+type rateLimitRuleQuery struct {
+	service model.ServiceKey
+	trigger model.NotifyTrigger
+	param   model.ControlParam
+	result  model.APICallResult
+	rule    model.ServiceRule
+}
+
+func (q *rateLimitRuleQuery) GetDstService() *model.ServiceKey         { return &q.service }
+func (q *rateLimitRuleQuery) GetSrcService() *model.ServiceKey         { return nil }
+func (q *rateLimitRuleQuery) GetNotifierTrigger() *model.NotifyTrigger { return &q.trigger }
+func (q *rateLimitRuleQuery) SetDstInstances(model.ServiceInstances)   {}
+func (q *rateLimitRuleQuery) SetDstRoute(model.ServiceRule)            {}
+func (q *rateLimitRuleQuery) SetDstRateLimit(rule model.ServiceRule)   { q.rule = rule }
+func (q *rateLimitRuleQuery) SetSrcRoute(model.ServiceRule)            {}
+func (q *rateLimitRuleQuery) GetControlParam() *model.ControlParam     { return &q.param }
+func (q *rateLimitRuleQuery) GetCallResult() *model.APICallResult      { return &q.result }
+func (q *rateLimitRuleQuery) SetMeshConfig(model.MeshConfig)           {}
@@ -0,0 +1,206 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/kitex-contrib/polaris/polaristest"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	namingpb "github.com/polarismesh/polaris-go/pkg/model/pb/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQPSLimiter(t *testing.T) {
+	svcName := "ratelimit-test"
+	testServer.SetRateLimit(DefaultPolarisNamespace, svcName, polaristest.QPSRule(2, time.Minute))
+
+	limiter, err := NewQPSLimiter(WithPolarisClient(testClient))
+	require.Nil(t, err)
+	limiter.WithNamespace(DefaultPolarisNamespace).WithServiceName(svcName)
+
+	require.True(t, limiter.Acquire(context.TODO()))
+	require.True(t, limiter.Acquire(context.TODO()))
+	require.False(t, limiter.Acquire(context.TODO()))
+
+	max, current, interval := limiter.Status(context.TODO())
+	require.Equal(t, 2, max)
+	require.Equal(t, 2, current)
+	require.Equal(t, time.Minute, interval)
+	m := limiter.Metrics()
+	require.Equal(t, uint64(2), m.Allowed)
+	require.Equal(t, uint64(1), m.Rejected[RejectLimited])
+	require.InDelta(t, 2.0/60, m.MaxQPS, 1e-9)
+
+	// the status follows the rule and reports its strictest amount
+	rule := polaristest.QPSRule(10, time.Second)
+	rule.Amounts = append(rule.Amounts, polaristest.QPSRule(5, time.Hour).Amounts...)
+	testServer.SetRateLimit(DefaultPolarisNamespace, svcName, rule)
+	require.Eventually(t, func() bool {
+		max, current, interval := limiter.Status(context.TODO())
+		return max == 5 && current == 0 && interval == time.Hour
+	}, polaristest.SyncTimeout, polaristest.RefreshInterval)
+}
+
+func TestQPSLimiterWithoutRule(t *testing.T) {
+	limiter, err := NewQPSLimiter(WithPolarisClient(testClient))
+	require.Nil(t, err)
+	defer limiter.Destroy()
+	svcName := "ratelimit-no-rule"
+	testServer.SetRateLimit(DefaultPolarisNamespace, svcName)
+	limiter.WithNamespace(DefaultPolarisNamespace).WithServiceName(svcName)
+
+	require.True(t, limiter.Acquire(context.TODO()))
+	max, current, _ := limiter.Status(context.TODO())
+	require.Equal(t, 0, max)
+	require.Equal(t, 1, current)
+}
+
+// stubLimitAPI allocates the quota with futures done after wait, or fails with err.
+type stubLimitAPI struct {
+	api.LimitAPI
+	wait     time.Duration
+	err      error
+	released int32
+}
+
+func (s *stubLimitAPI) GetQuota(request api.QuotaRequest) (api.QuotaFuture, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	f := &stubFuture{done: make(chan struct{}), released: &s.released}
+	if s.wait == 0 {
+		close(f.done)
+	} else {
+		time.AfterFunc(s.wait, func() { close(f.done) })
+	}
+	return f, nil
+}
+
+type stubFuture struct {
+	api.QuotaFuture
+	done     chan struct{}
+	released *int32
+}
+
+func (f *stubFuture) Release() {
+	atomic.AddInt32(f.released, 1)
+}
+
+func (f *stubFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+func (f *stubFuture) Get() *model.QuotaResponse {
+	<-f.done
+	return &model.QuotaResponse{Code: model.QuotaResultOk}
+}
+
+func TestQPSLimiterModes(t *testing.T) {
+	limiter, err := NewQPSLimiter(WithPolarisClient(testClient))
+	require.Nil(t, err)
+	defer limiter.Destroy()
+	var reasons []RejectReason
+	limiter.WithNamespace(DefaultPolarisNamespace).WithServiceName("ratelimit-modes").
+		WithObserver(func(event LimiterEvent) {
+			require.Equal(t, "ratelimit-modes", event.Service)
+			reasons = append(reasons, event.Reason)
+		})
+	stub := &stubLimitAPI{wait: 100 * time.Millisecond}
+	limiter.limitAPI = stub
+
+	// queue mode waits for the quota until the deadline of the request
+	require.True(t, limiter.Acquire(context.TODO()))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.False(t, limiter.Acquire(ctx))
+	// the quota abandoned is released
+	require.Equal(t, int32(1), atomic.LoadInt32(&stub.released))
+
+	// fail fast mode never waits
+	limiter.WithMode(LimiterModeFailFast)
+	begin := time.Now()
+	require.False(t, limiter.Acquire(context.TODO()))
+	require.True(t, time.Since(begin) < stub.wait)
+	require.Equal(t, int32(2), atomic.LoadInt32(&stub.released))
+	stub.wait = 0
+	require.True(t, limiter.Acquire(context.TODO()))
+
+	// fail open mode admits the requests when polaris fails
+	stub.err = errors.New("polaris is down")
+	require.False(t, limiter.Acquire(context.TODO()))
+	limiter.WithMode(LimiterModeFailOpen)
+	require.True(t, limiter.Acquire(context.TODO()))
+
+	m := limiter.Metrics()
+	require.Equal(t, uint64(3), m.Allowed)
+	require.Equal(t, uint64(1), m.FailOpen)
+	require.Equal(t, map[RejectReason]uint64{RejectTimeout: 1, RejectNotReady: 1, RejectError: 1}, m.Rejected)
+	require.Equal(t, []RejectReason{"", RejectTimeout, RejectNotReady, "", RejectError, ""}, reasons)
+}
+
+func TestActiveLimit(t *testing.T) {
+	withPriority := func(rule *namingpb.Rule, id string, priority uint32) *namingpb.Rule {
+		rule.Id = &wrappers.StringValue{Value: id}
+		rule.Priority = &wrappers.UInt32Value{Value: priority}
+		return rule
+	}
+	disabled := withPriority(polaristest.QPSRule(1, time.Second), "disabled", 0)
+	disabled.Disable = &wrappers.BoolValue{Value: true}
+	rateLimit := &namingpb.RateLimit{Rules: []*namingpb.Rule{
+		withPriority(polaristest.QPSRule(100, time.Second), "low", 5),
+		disabled,
+		withPriority(polaristest.QPSRule(20, time.Second), "high-b", 1),
+		withPriority(polaristest.QPSRule(10, time.Second), "high-a", 1),
+	}}
+	max, interval := activeLimit(rateLimit)
+	require.Equal(t, 10, max)
+	require.Equal(t, time.Second, interval)
+	require.Equal(t, "low", rateLimit.Rules[0].GetId().GetValue())
+}
+
+func TestQPSLimiterConcurrent(t *testing.T) {
+	svcName := "ratelimit-concurrent"
+	testServer.SetRateLimit(DefaultPolarisNamespace, svcName, polaristest.QPSRule(1000, time.Hour))
+
+	limiter, err := NewQPSLimiter(WithPolarisClient(testClient))
+	require.Nil(t, err)
+	defer limiter.Destroy()
+	limiter.WithNamespace(DefaultPolarisNamespace).WithServiceName(svcName)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				require.True(t, limiter.Acquire(context.TODO()))
+			}
+		}()
+	}
+	wg.Wait()
+	m := limiter.Metrics()
+	require.Equal(t, uint64(400), m.Allowed)
+	require.Equal(t, 400, m.Current)
+}
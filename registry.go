@@ -18,11 +18,16 @@ package polaris
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math/rand"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/cloudwego/kitex/pkg/discovery"
 	"github.com/cloudwego/kitex/pkg/registry"
 	perrors "github.com/pkg/errors"
 	"github.com/polarismesh/polaris-go/api"
@@ -30,26 +35,108 @@ import (
 	"github.com/polarismesh/polaris-go/pkg/model"
 )
 
-var (
-	defaultHeartbeatIntervalSec = 5
-	registerTimeout             = 10 * time.Second
-	heartbeatTimeout            = 5 * time.Second
-	heartbeatTime               = 5 * time.Second
+// Tags of registry.Info overriding the durations of ServerOptions for an instance, in the time.ParseDuration format.
+const (
+	TTLTagKey               = "ttl"
+	RegisterTimeoutTagKey   = "register_timeout"
+	HeartbeatIntervalTagKey = "heartbeat_interval"
+	HeartbeatTimeoutTagKey  = "heartbeat_timeout"
+)
+
+// Tags of registry.Info setting the attributes of the registered instance.
+const (
+	VersionTagKey  = "version"
+	PriorityTagKey = "priority"
+	HealthyTagKey  = "healthy"
+	IsolateTagKey  = "isolate"
+	RegionTagKey   = "region"
+	ZoneTagKey     = "zone"
+	CampusTagKey   = "campus"
+	// WeightTagKey sets the weight of the instance, including discovery.DefaultWeight which registry.Info.Weight can't.
+	WeightTagKey = "weight"
+)
+
+// reservedTagKeys are the tags of registry.Info which are not registered as metadata.
+var reservedTagKeys = map[string]struct{}{
+	NameSpaceTagKey:         {},
+	TTLTagKey:               {},
+	RegisterTimeoutTagKey:   {},
+	HeartbeatIntervalTagKey: {},
+	HeartbeatTimeoutTagKey:  {},
+	VersionTagKey:           {},
+	PriorityTagKey:          {},
+	WeightTagKey:            {},
+	HealthyTagKey:           {},
+	IsolateTagKey:           {},
+	RegionTagKey:            {},
+	ZoneTagKey:              {},
+	CampusTagKey:            {},
+}
+
+const (
+	defaultTTL             = 5 * time.Second
+	defaultRegisterTimeout = 10 * time.Second
 )
 
 // Registry is extension interface of Kitex registry.Registry.
 type Registry interface {
 	registry.Registry
-	doHeartbeat(ctx context.Context, ins *api.InstanceRegisterRequest)
+	doHeartbeat(ctx context.Context, insHeartbeat *polarisHeartbeat)
+	// UpdateInstance updates the attributes of a registered instance in place.
+	UpdateInstance(info *registry.Info, update InstanceUpdate) error
+	// ListRegistered returns the info of the instances registered by the registry.
+	ListRegistered() []*registry.Info
+	// DeregisterAll deregisters all the instances registered by the registry.
+	DeregisterAll() error
+	// Destroy stops the heartbeats and releases the polaris client used by the registry.
+	Destroy()
+}
+
+// InstanceUpdate changes the attributes of a registered instance, the nil fields are left unchanged.
+type InstanceUpdate struct {
+	Weight *int
+	// Metadata is merged into the metadata of the instance.
+	Metadata map[string]string
+	// RemoveMetadata are the metadata keys to remove from the instance.
+	RemoveMetadata []string
+	Isolate        *bool
+	// Healthy only matters to the instances without heartbeat health check, polaris sets it from the heartbeats otherwise.
+	Healthy *bool
 }
 
 type polarisHeartbeat struct {
 	cancel      context.CancelFunc
 	instanceKey string
+	info        registry.Info
+	reg         registration
+	// deregistering is set by the first Deregister, guarded by the lock of the registry.
+	deregistering bool
+
+	// lock serializes the requests registering the instance, or deregistering it, without blocking the registry.
+	lock    sync.Mutex
+	param   *api.InstanceRegisterRequest
+	stopped bool
+}
+
+// stop stops the heartbeat, after the request of the instance in progress if any.
+func (hb *polarisHeartbeat) stop() {
+	hb.lock.Lock()
+	defer hb.lock.Unlock()
+	hb.stopped = true
+	hb.cancel()
+}
+
+// registration holds the durations used to register an instance and keep it alive.
+type registration struct {
+	ttl              time.Duration
+	registerTimeout  time.Duration
+	interval         time.Duration
+	heartbeatTimeout time.Duration
 }
 
 // polarisRegistry is a registry using polaris.
 type polarisRegistry struct {
+	client      *clientRef
 	provider    api.ProviderAPI
 	lock        *sync.RWMutex
 	registryIns map[string]*polarisHeartbeat
@@ -57,14 +144,18 @@ type polarisRegistry struct {
 }
 
 // NewPolarisRegistry creates a polaris based registry.
-func NewPolarisRegistry(so ServerOptions, configFile ...string) (Registry, error) {
-	sdkCtx, err := GetPolarisConfig(configFile...)
+func NewPolarisRegistry(so ServerOptions, opts ...Option) (Registry, error) {
+	if _, err := newRegistration(nil, so); err != nil {
+		return nil, err
+	}
+	client, err := newClientRef(opts)
 	if err != nil {
 		return nil, err
 	}
 
 	pRegistry := &polarisRegistry{
-		provider:    api.NewProviderAPIByContext(sdkCtx),
+		client:      client,
+		provider:    api.NewProviderAPIByContext(client.SDKContext()),
 		registryIns: make(map[string]*polarisHeartbeat),
 		lock:        &sync.RWMutex{},
 		so:          so,
@@ -78,7 +169,11 @@ func (svr *polarisRegistry) Register(info *registry.Info) error {
 	if err := validateInfo(info); err != nil {
 		return err
 	}
-	param, instanceKey, err := createRegisterParam(info, svr.so)
+	reg, err := newRegistration(info, svr.so)
+	if err != nil {
+		return err
+	}
+	param, instanceKey, err := createRegisterParam(info, svr.so, reg)
 	if err != nil {
 		return err
 	}
@@ -91,12 +186,21 @@ func (svr *polarisRegistry) Register(info *registry.Info) error {
 			param.Namespace, param.Service, param.Host)
 	}
 	ctx, cancel := context.WithCancel(context.Background())
-	go svr.doHeartbeat(ctx, param)
-	svr.lock.Lock()
-	defer svr.lock.Unlock()
-	svr.registryIns[instanceKey] = &polarisHeartbeat{
+	insHeartbeat := &polarisHeartbeat{
 		instanceKey: instanceKey,
+		info:        *info,
 		cancel:      cancel,
+		param:       param,
+		reg:         reg,
+	}
+	svr.lock.Lock()
+	prev := svr.registryIns[instanceKey]
+	svr.registryIns[instanceKey] = insHeartbeat
+	go svr.doHeartbeat(ctx, insHeartbeat)
+	svr.lock.Unlock()
+	// registering the instance again replaces its heartbeat
+	if prev != nil {
+		prev.stop()
 	}
 	return nil
 }
@@ -106,27 +210,148 @@ func (svr *polarisRegistry) Deregister(info *registry.Info) error {
 	if err := validateInfo(info); err != nil {
 		return err
 	}
-	request, instanceKey, err := createDeregisterParam(info)
+	request, instanceKey, err := createDeregisterParam(info, svr.so)
 	if err != nil {
 		return err
 	}
-	svr.lock.RLock()
+	svr.lock.Lock()
 	insHeartbeat, ok := svr.registryIns[instanceKey]
-	svr.lock.RUnlock()
 	if !ok {
-		err = perrors.Errorf("instance{%s} has not registered", instanceKey)
-		return err
+		svr.lock.Unlock()
+		return perrors.Errorf("instance{%s} has not registered", instanceKey)
 	}
+	if insHeartbeat.deregistering {
+		svr.lock.Unlock()
+		return perrors.Errorf("instance{%s} is being deregistered", instanceKey)
+	}
+	insHeartbeat.deregistering = true
+	svr.lock.Unlock()
+
+	request.Timeout = model.ToDurationPtr(insHeartbeat.reg.registerTimeout)
+	if drain := svr.so.Drain; drain.Enable {
+		svr.drain(info, insHeartbeat)
+		timeout := drain.timeout(drain.DeregisterTimeout, insHeartbeat.reg.registerTimeout)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		drain.runHook(ctx, DrainPhaseDeregister, info)
+		cancel()
+		request.Timeout = model.ToDurationPtr(timeout)
+	}
+	// the lock of the instance keeps the heartbeat from registering it again meanwhile
+	insHeartbeat.lock.Lock()
 	err = svr.provider.Deregister(request)
+	if err == nil {
+		insHeartbeat.stopped = true
+		insHeartbeat.cancel()
+	}
+	insHeartbeat.lock.Unlock()
+
+	svr.lock.Lock()
+	defer svr.lock.Unlock()
 	if err != nil {
+		insHeartbeat.deregistering = false
 		return perrors.WithMessagef(err, "instance{%s} deregister fail (err:%+v)", instanceKey, err)
-	} else {
-		svr.lock.Lock()
-		insHeartbeat.cancel()
+	}
+	// the instance may have been registered again meanwhile
+	if svr.registryIns[instanceKey] == insHeartbeat {
 		delete(svr.registryIns, instanceKey)
-		svr.lock.Unlock()
 	}
+	return nil
+}
 
+// UpdateInstance updates the weight, metadata, isolation or healthy flag of a registered instance.
+// The update is kept when the heartbeat registers the instance again.
+func (svr *polarisRegistry) UpdateInstance(info *registry.Info, update InstanceUpdate) error {
+	if err := validateInfo(info); err != nil {
+		return err
+	}
+	_, instanceKey, err := createDeregisterParam(info, svr.so)
+	if err != nil {
+		return err
+	}
+	svr.lock.RLock()
+	insHeartbeat, ok := svr.registryIns[instanceKey]
+	svr.lock.RUnlock()
+	if !ok {
+		return perrors.Errorf("instance{%s} has not registered", instanceKey)
+	}
+	// the lock of the instance keeps the heartbeat and the other updates from registering an outdated instance meanwhile
+	insHeartbeat.lock.Lock()
+	defer insHeartbeat.lock.Unlock()
+	if insHeartbeat.stopped {
+		return perrors.Errorf("instance{%s} has been deregistered", instanceKey)
+	}
+	param := *insHeartbeat.param
+	if update.Weight != nil {
+		weight := *update.Weight
+		param.Weight = &weight
+	}
+	if update.Isolate != nil {
+		param.SetIsolate(*update.Isolate)
+	}
+	if update.Healthy != nil {
+		param.SetHealthy(*update.Healthy)
+	}
+	if len(update.Metadata) != 0 || len(update.RemoveMetadata) != 0 {
+		metadata := make(map[string]string, len(param.Metadata)+len(update.Metadata))
+		for key, value := range param.Metadata {
+			metadata[key] = value
+		}
+		for key, value := range update.Metadata {
+			metadata[key] = value
+		}
+		for _, key := range update.RemoveMetadata {
+			delete(metadata, key)
+		}
+		param.Metadata = metadata
+	}
+	// polaris updates the instance registered again
+	if _, err = svr.provider.Register(&param); err != nil {
+		return perrors.WithMessagef(err, "instance{%s} update fail", instanceKey)
+	}
+	insHeartbeat.param = &param
+	return nil
+}
+
+// ListRegistered returns the info of the registered instances, sorted by namespace, service and address.
+func (svr *polarisRegistry) ListRegistered() []*registry.Info {
+	svr.lock.RLock()
+	defer svr.lock.RUnlock()
+	keys := make([]string, 0, len(svr.registryIns))
+	for instanceKey := range svr.registryIns {
+		keys = append(keys, instanceKey)
+	}
+	sort.Strings(keys)
+	infos := make([]*registry.Info, 0, len(keys))
+	for _, instanceKey := range keys {
+		info := svr.registryIns[instanceKey].info
+		infos = append(infos, &info)
+	}
+	return infos
+}
+
+// DeregisterAll deregisters the registered instances concurrently, so their drains overlap.
+func (svr *polarisRegistry) DeregisterAll() error {
+	infos := svr.ListRegistered()
+	errs := make([]error, len(infos))
+	var wg sync.WaitGroup
+	for i, info := range infos {
+		wg.Add(1)
+		go func(i int, info *registry.Info) {
+			defer wg.Done()
+			errs[i] = svr.Deregister(info)
+		}(i, info)
+	}
+	wg.Wait()
+
+	var msgs []string
+	for _, err := range errs {
+		if err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if len(msgs) != 0 {
+		return fmt.Errorf("fail to deregister %d of %d instances: %s", len(msgs), len(infos), strings.Join(msgs, "; "))
+	}
 	return nil
 }
 
@@ -135,9 +360,71 @@ func (svr *polarisRegistry) IsAvailable() bool {
 	return true
 }
 
+// Destroy implements the Registry interface.
+func (svr *polarisRegistry) Destroy() {
+	svr.lock.Lock()
+	heartbeats := make([]*polarisHeartbeat, 0, len(svr.registryIns))
+	for instanceKey, insHeartbeat := range svr.registryIns {
+		heartbeats = append(heartbeats, insHeartbeat)
+		delete(svr.registryIns, instanceKey)
+	}
+	svr.lock.Unlock()
+	for _, insHeartbeat := range heartbeats {
+		insHeartbeat.stop()
+	}
+	svr.client.Destroy()
+}
+
+// drain isolates the instance, or sets its weight to 0, then waits for the clients to notice it.
+// The heartbeat goes on until the instance is deregistered.
+func (svr *polarisRegistry) drain(info *registry.Info, insHeartbeat *polarisHeartbeat) {
+	drain := svr.so.Drain
+
+	timeout := drain.timeout(drain.MarkTimeout, insHeartbeat.reg.registerTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	drain.runHook(ctx, DrainPhaseMark, info)
+	cancel()
+	if err := svr.mark(insHeartbeat, timeout); err != nil {
+		log.GetBaseLogger().Warnf("fail to mark instance %s before deregistration, err is %v", insHeartbeat.instanceKey, err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), drain.PropagationWait)
+	defer cancel()
+	drain.runHook(ctx, DrainPhaseWait, info)
+	<-ctx.Done()
+}
+
+// mark isolates the instance or sets its weight to 0, an instance registered again by the heartbeat stays marked.
+func (svr *polarisRegistry) mark(insHeartbeat *polarisHeartbeat, timeout time.Duration) error {
+	insHeartbeat.lock.Lock()
+	defer insHeartbeat.lock.Unlock()
+	if insHeartbeat.stopped {
+		return perrors.Errorf("instance{%s} has been registered again", insHeartbeat.instanceKey)
+	}
+	// polaris updates the instance registered again
+	mark := *insHeartbeat.param
+	if svr.so.Drain.ZeroWeight {
+		weight := 0
+		mark.Weight = &weight
+	} else {
+		mark.SetIsolate(true)
+	}
+	mark.Timeout = model.ToDurationPtr(timeout)
+	if _, err := svr.provider.Register(&mark); err != nil {
+		return err
+	}
+	insHeartbeat.param = &mark
+	return nil
+}
+
 // doHeartbeat Since polaris does not support automatic reporting of instance heartbeats, separate logic is needed to implement it.
-func (svr *polarisRegistry) doHeartbeat(ctx context.Context, ins *api.InstanceRegisterRequest) {
-	ticker := time.NewTicker(heartbeatTime)
+// A failed heartbeat is retried with an exponential backoff, and the instance is registered again if polaris lost it.
+func (svr *polarisRegistry) doHeartbeat(ctx context.Context, insHeartbeat *polarisHeartbeat) {
+	insHeartbeat.lock.Lock()
+	ins, reg := insHeartbeat.param, insHeartbeat.reg
+	insHeartbeat.lock.Unlock()
+	timer := time.NewTimer(reg.nextHeartbeat())
+	defer timer.Stop()
 
 	heartbeat := &api.InstanceHeartbeatRequest{
 		InstanceHeartbeatRequest: model.InstanceHeartbeatRequest{
@@ -145,18 +432,85 @@ func (svr *polarisRegistry) doHeartbeat(ctx context.Context, ins *api.InstanceRe
 			Namespace: ins.Namespace,
 			Host:      ins.Host,
 			Port:      ins.Port,
-			Timeout:   model.ToDurationPtr(heartbeatTimeout),
+			Timeout:   model.ToDurationPtr(reg.heartbeatTimeout),
 		},
 	}
+	event := RegistrationEvent{
+		Namespace: ins.Namespace,
+		Service:   ins.Service,
+		Host:      ins.Host,
+		Port:      ins.Port,
+	}
+	desc := ins.Namespace + ":" + ins.Service
 	for {
 		select {
 		case <-ctx.Done():
-			ticker.Stop()
 			return
-		case <-ticker.C:
-			svr.provider.Heartbeat(heartbeat)
+		case <-timer.C:
 		}
+		err := svr.provider.Heartbeat(heartbeat)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			if event.Failures > 0 {
+				event.State, event.Failures, event.Err = RegistrationHealthy, 0, nil
+				svr.so.Heartbeat.notify(event)
+			}
+			timer.Reset(reg.nextHeartbeat())
+			continue
+		}
+		pErr := newPolarisError("Heartbeat", desc, err)
+		var regErr error
+		reregistering := errors.Is(pErr, ErrInstanceNotFound) || rejectedByServer(pErr)
+		if reregistering {
+			// the SDK doesn't report the code of a rejected heartbeat, registering the instance again
+			// tells whether polaris lost it
+			var existed bool
+			if existed, regErr = svr.reregister(ctx, insHeartbeat); regErr == nil && !existed {
+				pErr.Kind = ErrInstanceNotFound
+			}
+		}
+		event.Failures++
+		event.State, event.Err = RegistrationHeartbeatFailed, pErr
+		log.GetBaseLogger().Warnf("heartbeat of instance %s:%d failed %d times, err is %v",
+			ins.Host, ins.Port, event.Failures, pErr)
+		svr.so.Heartbeat.notify(event)
+		if reregistering && regErr == nil && errors.Is(pErr, ErrInstanceNotFound) {
+			event.State, event.Failures, event.Err = RegistrationReregistered, 0, nil
+			svr.so.Heartbeat.notify(event)
+			timer.Reset(reg.nextHeartbeat())
+			continue
+		}
+		if regErr != nil {
+			event.State, event.Err = RegistrationReregisterFailed, regErr
+			log.GetBaseLogger().Errorf("fail to register instance %s:%d again, err is %v", ins.Host, ins.Port, regErr)
+			svr.so.Heartbeat.notify(event)
+		}
+		timer.Reset(svr.so.Heartbeat.backoff(event.Failures, reg.interval))
+	}
+}
+
+// reregister registers the instance lost by polaris again, unless it has been deregistered
+// or registered again by another heartbeat. It reports whether polaris still had the instance.
+func (svr *polarisRegistry) reregister(ctx context.Context, insHeartbeat *polarisHeartbeat) (bool, error) {
+	svr.lock.RLock()
+	current := svr.registryIns[insHeartbeat.instanceKey] == insHeartbeat
+	svr.lock.RUnlock()
+	if !current {
+		return false, perrors.Errorf("instance{%s} has been deregistered", insHeartbeat.instanceKey)
 	}
+	insHeartbeat.lock.Lock()
+	defer insHeartbeat.lock.Unlock()
+	// the heartbeat is stopped with the lock held, once deregistered or registered again
+	if insHeartbeat.stopped || ctx.Err() != nil {
+		return false, perrors.Errorf("instance{%s} has been deregistered", insHeartbeat.instanceKey)
+	}
+	resp, err := svr.provider.Register(insHeartbeat.param)
+	if err != nil {
+		return false, err
+	}
+	return resp.Existed, nil
 }
 
 // validateInfo validates registry.Info.
@@ -177,18 +531,16 @@ func validateInfo(info *registry.Info) error {
 }
 
 // createRegisterParam convert registry.Info to polaris instance register request.
-func createRegisterParam(info *registry.Info, so ServerOptions) (*api.InstanceRegisterRequest, string, error) {
-	instanceHost, instancePort, err := GetInfoHostAndPort(info.Addr.String())
+func createRegisterParam(info *registry.Info, so ServerOptions, reg registration) (*api.InstanceRegisterRequest, string, error) {
+	instanceHost, instancePort, err := GetInfoHostAndPort(info.Addr.String(), so.Address)
 	if err != nil {
 		return nil, "", err
 	}
 	protocol := info.Addr.Network()
 
-	namespace, ok := info.Tags[NameSpaceTagKey]
-	if !ok {
-		namespace = DefaultPolarisNamespace
-	}
+	namespace := infoNamespace(info, so)
 	instanceKey := GetInstanceKey(namespace, info.ServiceName, instanceHost, strconv.Itoa(instancePort))
+	ttl := int(reg.ttl / time.Second)
 
 	req := &api.InstanceRegisterRequest{
 		InstanceRegisterRequest: model.InstanceRegisterRequest{
@@ -197,28 +549,86 @@ func createRegisterParam(info *registry.Info, so ServerOptions) (*api.InstanceRe
 			Host:      instanceHost,
 			Port:      instancePort,
 			Protocol:  &protocol,
-			Timeout:   model.ToDurationPtr(registerTimeout),
-			TTL:       &defaultHeartbeatIntervalSec,
-			Metadata:  so.Metadata,
+			Timeout:   model.ToDurationPtr(reg.registerTimeout),
+			TTL:       &ttl,
+			Metadata:  infoMetadata(info, so),
 			// If the TTL field is not set, polaris will think that this instance does not need to perform the heartbeat health check operation,
 			// then after the instance goes offline, the instance cannot be converted to unhealthy normally.
 		},
 	}
+	// Kitex sets the weight to discovery.DefaultWeight when it is not configured,
+	// which is left to polaris so the instance gets the default weight of polaris.
+	if info.Weight > 0 && info.Weight != discovery.DefaultWeight {
+		weight := info.Weight
+		req.Weight = &weight
+	}
+	if err = applyInfoTags(&req.InstanceRegisterRequest, info.Tags); err != nil {
+		return nil, "", err
+	}
 
 	return req, instanceKey, nil
 }
 
+// applyInfoTags sets the attributes of the instance given by the tags of registry.Info.
+func applyInfoTags(req *model.InstanceRegisterRequest, tags map[string]string) error {
+	if version, ok := tags[VersionTagKey]; ok {
+		req.Version = &version
+	}
+	if priority, ok := tags[PriorityTagKey]; ok {
+		p, err := strconv.Atoi(priority)
+		if err != nil {
+			return fmt.Errorf("invalid %s tag %q: %v", PriorityTagKey, priority, err)
+		}
+		req.Priority = &p
+	}
+	if weight, ok := tags[WeightTagKey]; ok {
+		w, err := strconv.Atoi(weight)
+		if err != nil {
+			return fmt.Errorf("invalid %s tag %q: %v", WeightTagKey, weight, err)
+		}
+		req.Weight = &w
+	}
+	for key, set := range map[string]func(bool){HealthyTagKey: req.SetHealthy, IsolateTagKey: req.SetIsolate} {
+		value, ok := tags[key]
+		if !ok {
+			continue
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s tag %q: %v", key, value, err)
+		}
+		set(b)
+	}
+	region, zone, campus := tags[RegionTagKey], tags[ZoneTagKey], tags[CampusTagKey]
+	if len(region) != 0 || len(zone) != 0 || len(campus) != 0 {
+		req.Location = &model.Location{Region: region, Zone: zone, Campus: campus}
+	}
+	return nil
+}
+
+// infoMetadata merges the tags of registry.Info into ServerOptions.Metadata, the tags win.
+// The tags mapped to the attributes of the instance are not metadata.
+func infoMetadata(info *registry.Info, so ServerOptions) map[string]string {
+	metadata := make(map[string]string, len(so.Metadata)+len(info.Tags))
+	for key, value := range so.Metadata {
+		metadata[key] = value
+	}
+	for key, value := range info.Tags {
+		if _, ok := reservedTagKeys[key]; !ok {
+			metadata[key] = value
+		}
+	}
+	return metadata
+}
+
 // createDeregisterParam convert registry.info to polaris instance deregister request.
-func createDeregisterParam(info *registry.Info) (*api.InstanceDeRegisterRequest, string, error) {
-	instanceHost, instancePort, err := GetInfoHostAndPort(info.Addr.String())
+func createDeregisterParam(info *registry.Info, so ServerOptions) (*api.InstanceDeRegisterRequest, string, error) {
+	instanceHost, instancePort, err := GetInfoHostAndPort(info.Addr.String(), so.Address)
 	if err != nil {
 		return nil, "", err
 	}
 
-	namespace, ok := info.Tags["namespace"]
-	if !ok {
-		namespace = DefaultPolarisNamespace
-	}
+	namespace := infoNamespace(info, so)
 
 	instanceKey := GetInstanceKey(namespace, info.ServiceName, instanceHost, strconv.Itoa(instancePort))
 	req := &api.InstanceDeRegisterRequest{
@@ -231,3 +641,116 @@ func createDeregisterParam(info *registry.Info) (*api.InstanceDeRegisterRequest,
 	}
 	return req, instanceKey, nil
 }
+
+// newRegistration resolves the durations of the instance from the tags of info and so, then validates them.
+func newRegistration(info *registry.Info, so ServerOptions) (registration, error) {
+	reg := registration{
+		ttl:              so.TTL,
+		registerTimeout:  so.RegisterTimeout,
+		interval:         so.Heartbeat.Interval,
+		heartbeatTimeout: so.Heartbeat.Timeout,
+	}
+	if info != nil {
+		tags := map[string]*time.Duration{
+			TTLTagKey:               &reg.ttl,
+			RegisterTimeoutTagKey:   &reg.registerTimeout,
+			HeartbeatIntervalTagKey: &reg.interval,
+			HeartbeatTimeoutTagKey:  &reg.heartbeatTimeout,
+		}
+		for key, d := range tags {
+			value, ok := info.Tags[key]
+			if !ok {
+				continue
+			}
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				return reg, fmt.Errorf("invalid %s tag %q: %v", key, value, err)
+			}
+			*d = parsed
+		}
+	}
+	if reg.ttl == 0 {
+		reg.ttl = defaultTTL
+	}
+	if reg.registerTimeout == 0 {
+		reg.registerTimeout = defaultRegisterTimeout
+	}
+	if reg.interval == 0 {
+		reg.interval = reg.ttl / 2
+	}
+	if reg.heartbeatTimeout == 0 {
+		reg.heartbeatTimeout = reg.interval
+	}
+	return reg, reg.validate()
+}
+
+func (reg registration) validate() error {
+	switch {
+	case reg.ttl < time.Second || reg.ttl%time.Second != 0:
+		return fmt.Errorf("TTL %v must be a whole number of seconds", reg.ttl)
+	case reg.registerTimeout < 0:
+		return fmt.Errorf("register timeout %v can not be negative", reg.registerTimeout)
+	case reg.interval <= 0 || reg.interval >= reg.ttl:
+		return fmt.Errorf("heartbeat interval %v must be positive and shorter than the TTL %v", reg.interval, reg.ttl)
+	case reg.heartbeatTimeout <= 0 || reg.heartbeatTimeout >= reg.ttl:
+		return fmt.Errorf("heartbeat timeout %v must be positive and shorter than the TTL %v", reg.heartbeatTimeout, reg.ttl)
+	}
+	return nil
+}
+
+// nextHeartbeat returns the delay before the next heartbeat, jittered by 10% so the instances don't beat together.
+func (reg registration) nextHeartbeat() time.Duration {
+	jitter := int64(reg.interval / 10)
+	if jitter == 0 {
+		return reg.interval
+	}
+	return reg.interval + time.Duration(rand.Int63n(2*jitter+1)-jitter)
+}
+
+// infoNamespace returns the namespace of the instance, the namespace tag of info takes precedence over ServerOptions.
+func infoNamespace(info *registry.Info, so ServerOptions) string {
+	if namespace, ok := info.Tags[NameSpaceTagKey]; ok {
+		return namespace
+	}
+	return so.namespace()
+}
+
+func (d DrainOptions) timeout(timeout, registerTimeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		return registerTimeout
+	}
+	return timeout
+}
+
+func (d DrainOptions) runHook(ctx context.Context, phase DrainPhase, info *registry.Info) {
+	if d.Hook == nil {
+		return
+	}
+	if err := d.Hook(ctx, phase, info); err != nil {
+		log.GetBaseLogger().Warnf("drain hook of phase %d failed, err is %v", phase, err)
+	}
+}
+
+// backoff returns the delay before retrying after the given number of failed heartbeats.
+func (h HeartbeatOptions) backoff(failures int, interval time.Duration) time.Duration {
+	delay, max := h.RetryBackoff, h.MaxRetryBackoff
+	if delay <= 0 {
+		delay = time.Second
+	}
+	if max <= 0 {
+		max = interval
+	}
+	for i := 1; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+func (h HeartbeatOptions) notify(event RegistrationEvent) {
+	if h.OnEvent != nil {
+		h.OnEvent(event)
+	}
+}
@@ -0,0 +1,460 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/registry"
+	"github.com/cloudwego/kitex/pkg/utils"
+	"github.com/kitex-contrib/polaris/polaristest"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolarisRegistry(t *testing.T) {
+	so := ServerOptions{
+		Metadata:  map[string]string{"env": "test"},
+		Heartbeat: HeartbeatOptions{Interval: 50 * time.Millisecond},
+	}
+	rg, err := NewPolarisRegistry(so, WithPolarisClient(testClient))
+	require.Nil(t, err)
+
+	info := &registry.Info{
+		ServiceName: "registry-heartbeat",
+		Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8888"),
+		Tags:        map[string]string{NameSpaceTagKey: "test"},
+	}
+	err = rg.Register(info)
+	require.Nil(t, err)
+	instances := testServer.Instances("test", info.ServiceName)
+	require.Len(t, instances, 1)
+	require.Equal(t, "tcp", instances[0].Protocol)
+	require.Equal(t, "test", instances[0].Metadata["env"])
+	require.Equal(t, 5, instances[0].TTL)
+
+	require.Eventually(t, func() bool {
+		return testServer.Heartbeats("test", info.ServiceName, "127.0.0.1", 8888) > 0
+	}, 2*time.Second, 50*time.Millisecond)
+
+	err = rg.Deregister(info)
+	require.Nil(t, err)
+	require.Empty(t, testServer.Instances("test", info.ServiceName))
+
+	// deregister an instance which is not registered
+	err = rg.Deregister(info)
+	require.NotNil(t, err)
+}
+
+func TestRegisterInstanceAttributes(t *testing.T) {
+	so := ServerOptions{Metadata: map[string]string{"env": "test", "app": "demo"}}
+	rg, err := NewPolarisRegistry(so, WithPolarisClient(testClient))
+	require.Nil(t, err)
+	defer rg.Destroy()
+
+	info := &registry.Info{
+		ServiceName: "registry-attributes",
+		Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8892"),
+		Weight:      50,
+		Tags: map[string]string{
+			VersionTagKey:  "1.0.0",
+			PriorityTagKey: "2",
+			IsolateTagKey:  "true",
+			RegionTagKey:   "south",
+			ZoneTagKey:     "sz",
+			CampusTagKey:   "sz-1",
+			TTLTagKey:      "10s",
+			"env":          "staging",
+		},
+	}
+	err = rg.Register(info)
+	require.Nil(t, err)
+	instances := testServer.Instances(DefaultPolarisNamespace, info.ServiceName)
+	require.Len(t, instances, 1)
+	ins := instances[0]
+	require.Equal(t, 50, ins.Weight)
+	require.Equal(t, "1.0.0", ins.Version)
+	require.Equal(t, 2, ins.Priority)
+	require.True(t, ins.Isolate)
+	require.Equal(t, "south", ins.Region)
+	require.Equal(t, "sz", ins.Zone)
+	require.Equal(t, "sz-1", ins.Campus)
+	require.Equal(t, 10, ins.TTL)
+	require.Equal(t, map[string]string{"env": "staging", "app": "demo"}, ins.Metadata)
+	require.Nil(t, rg.Deregister(info))
+
+	info.Tags = map[string]string{PriorityTagKey: "high"}
+	require.NotNil(t, rg.Register(info))
+}
+
+func TestRegisterDefaultWeight(t *testing.T) {
+	rg, err := NewPolarisRegistry(ServerOptions{}, WithPolarisClient(testClient))
+	require.Nil(t, err)
+	defer rg.Destroy()
+
+	// the weight set by Kitex when none is configured
+	info := &registry.Info{
+		ServiceName: "registry-default-weight",
+		Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8896"),
+		Weight:      discovery.DefaultWeight,
+	}
+	require.Nil(t, rg.Register(info))
+	instances := testServer.Instances(DefaultPolarisNamespace, info.ServiceName)
+	require.Len(t, instances, 1)
+	require.Equal(t, 100, instances[0].Weight)
+	require.Nil(t, rg.Deregister(info))
+
+	info.Tags = map[string]string{WeightTagKey: "10"}
+	require.Nil(t, rg.Register(info))
+	instances = testServer.Instances(DefaultPolarisNamespace, info.ServiceName)
+	require.Len(t, instances, 1)
+	require.Equal(t, 10, instances[0].Weight)
+	require.Empty(t, instances[0].Metadata)
+	require.Nil(t, rg.Deregister(info))
+}
+
+func TestRegistryValidateInfo(t *testing.T) {
+	rg, err := NewPolarisRegistry(ServerOptions{}, WithPolarisClient(testClient))
+	require.Nil(t, err)
+
+	err = rg.Register(&registry.Info{Addr: utils.NewNetAddr("tcp", "127.0.0.1:8888")})
+	require.NotNil(t, err)
+	err = rg.Register(&registry.Info{ServiceName: "registry-validate"})
+	require.NotNil(t, err)
+}
+
+func TestPolarisRegistryReregister(t *testing.T) {
+	events := make(chan RegistrationEvent, 16)
+	so := ServerOptions{Heartbeat: HeartbeatOptions{
+		Interval:     50 * time.Millisecond,
+		RetryBackoff: 10 * time.Millisecond,
+		OnEvent:      func(event RegistrationEvent) { events <- event },
+	}}
+	rg, err := NewPolarisRegistry(so, WithPolarisClient(testClient))
+	require.Nil(t, err)
+
+	info := &registry.Info{
+		ServiceName: "registry-reregister",
+		Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8890"),
+	}
+	err = rg.Register(info)
+	require.Nil(t, err)
+	require.Eventually(t, func() bool {
+		return testServer.Heartbeats(DefaultPolarisNamespace, info.ServiceName, "127.0.0.1", 8890) > 0
+	}, 2*time.Second, 50*time.Millisecond)
+
+	// polaris loses the instance, e.g. when it restarts
+	require.True(t, testServer.RemoveInstance(DefaultPolarisNamespace, info.ServiceName, "127.0.0.1", 8890))
+	event := <-events
+	require.Equal(t, RegistrationHeartbeatFailed, event.State)
+	require.Equal(t, 1, event.Failures)
+	require.True(t, errors.Is(event.Err, ErrInstanceNotFound))
+	event = <-events
+	require.Equal(t, RegistrationReregistered, event.State)
+	require.Equal(t, "registry-reregister", event.Service)
+	require.Equal(t, 8890, event.Port)
+	require.Len(t, testServer.Instances(DefaultPolarisNamespace, info.ServiceName), 1)
+
+	err = rg.Deregister(info)
+	require.Nil(t, err)
+	require.Empty(t, testServer.Instances(DefaultPolarisNamespace, info.ServiceName))
+}
+
+func TestPolarisRegistryRegisterTwice(t *testing.T) {
+	events := make(chan RegistrationEvent, 16)
+	so := ServerOptions{Heartbeat: HeartbeatOptions{
+		Interval:     50 * time.Millisecond,
+		RetryBackoff: 10 * time.Millisecond,
+		OnEvent:      func(event RegistrationEvent) { events <- event },
+	}}
+	rg, err := NewPolarisRegistry(so, WithPolarisClient(testClient))
+	require.Nil(t, err)
+	defer rg.Destroy()
+
+	info := &registry.Info{
+		ServiceName: "registry-register-twice",
+		Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8897"),
+	}
+	require.Nil(t, rg.Register(info))
+	require.Nil(t, rg.Register(info))
+	require.Len(t, rg.ListRegistered(), 1)
+	require.Eventually(t, func() bool {
+		return testServer.Heartbeats(DefaultPolarisNamespace, info.ServiceName, "127.0.0.1", 8897) > 0
+	}, 2*time.Second, 50*time.Millisecond)
+
+	// no heartbeat registers the deregistered instance again
+	require.Nil(t, rg.Deregister(info))
+	require.Never(t, func() bool {
+		return len(testServer.Instances(DefaultPolarisNamespace, info.ServiceName)) != 0
+	}, polaristest.SyncTimeout, polaristest.RefreshInterval)
+	for len(events) > 0 {
+		require.NotEqual(t, RegistrationReregistered, (<-events).State)
+	}
+}
+
+// blockingProvider blocks the deregistrations until release is closed.
+type blockingProvider struct {
+	api.ProviderAPI
+	deregistering chan struct{}
+	release       chan struct{}
+	deregistered  int32
+}
+
+func (p *blockingProvider) Deregister(request *api.InstanceDeRegisterRequest) error {
+	atomic.AddInt32(&p.deregistered, 1)
+	p.deregistering <- struct{}{}
+	<-p.release
+	return p.ProviderAPI.Deregister(request)
+}
+
+func TestPolarisRegistryDeregisterConcurrently(t *testing.T) {
+	rg, err := NewPolarisRegistry(ServerOptions{Heartbeat: HeartbeatOptions{Interval: 50 * time.Millisecond}}, WithPolarisClient(testClient))
+	require.Nil(t, err)
+	defer rg.Destroy()
+	provider := &blockingProvider{
+		ProviderAPI:   rg.(*polarisRegistry).provider,
+		deregistering: make(chan struct{}, 1),
+		release:       make(chan struct{}),
+	}
+	rg.(*polarisRegistry).provider = provider
+
+	info := &registry.Info{
+		ServiceName: "registry-deregister-concurrently",
+		Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8898"),
+	}
+	require.Nil(t, rg.Register(info))
+	deregistered := make(chan error, 1)
+	go func() { deregistered <- rg.Deregister(info) }()
+	<-provider.deregistering
+
+	// the registry is not locked during the request, a second deregistration gives up
+	require.Len(t, rg.ListRegistered(), 1)
+	require.NotNil(t, rg.Deregister(info))
+	// the instance registered again meanwhile is kept, with its heartbeat
+	registered := make(chan error, 1)
+	go func() { registered <- rg.Register(info) }()
+	close(provider.release)
+	require.Nil(t, <-deregistered)
+	require.Nil(t, <-registered)
+	require.Equal(t, int32(1), atomic.LoadInt32(&provider.deregistered))
+	require.Len(t, rg.ListRegistered(), 1)
+	require.Eventually(t, func() bool {
+		return len(testServer.Instances(DefaultPolarisNamespace, info.ServiceName)) == 1
+	}, polaristest.SyncTimeout, polaristest.RefreshInterval)
+}
+
+func TestPolarisRegistryUpdateInstance(t *testing.T) {
+	events := make(chan RegistrationEvent, 16)
+	so := ServerOptions{
+		Metadata: map[string]string{"env": "test", "lane": "blue"},
+		Heartbeat: HeartbeatOptions{
+			Interval: 50 * time.Millisecond,
+			OnEvent:  func(event RegistrationEvent) { events <- event },
+		},
+	}
+	rg, err := NewPolarisRegistry(so, WithPolarisClient(testClient))
+	require.Nil(t, err)
+	defer rg.Destroy()
+
+	info := &registry.Info{
+		ServiceName: "registry-update",
+		Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8893"),
+	}
+	weight := 30
+	err = rg.UpdateInstance(info, InstanceUpdate{Weight: &weight})
+	require.NotNil(t, err)
+
+	require.Nil(t, rg.Register(info))
+	isolate := true
+	err = rg.UpdateInstance(info, InstanceUpdate{
+		Weight:         &weight,
+		Metadata:       map[string]string{"canary": "true"},
+		RemoveMetadata: []string{"lane"},
+		Isolate:        &isolate,
+	})
+	require.Nil(t, err)
+	instances := testServer.Instances(DefaultPolarisNamespace, info.ServiceName)
+	require.Len(t, instances, 1)
+	require.Equal(t, 30, instances[0].Weight)
+	require.True(t, instances[0].Isolate)
+	require.Equal(t, map[string]string{"env": "test", "canary": "true"}, instances[0].Metadata)
+
+	// the instance registered again by the heartbeat keeps the update
+	require.True(t, testServer.RemoveInstance(DefaultPolarisNamespace, info.ServiceName, "127.0.0.1", 8893))
+	for event := range events {
+		if event.State == RegistrationReregistered {
+			break
+		}
+	}
+	instances = testServer.Instances(DefaultPolarisNamespace, info.ServiceName)
+	require.Len(t, instances, 1)
+	require.Equal(t, 30, instances[0].Weight)
+	require.True(t, instances[0].Isolate)
+	require.Equal(t, "true", instances[0].Metadata["canary"])
+
+	require.Nil(t, rg.Deregister(info))
+}
+
+func TestPolarisRegistryMultipleServices(t *testing.T) {
+	rg, err := NewPolarisRegistry(ServerOptions{Metadata: map[string]string{"env": "test"}}, WithPolarisClient(testClient))
+	require.Nil(t, err)
+	defer rg.Destroy()
+
+	infos := []*registry.Info{
+		{
+			ServiceName: "registry-multi-echo",
+			Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8894"),
+			Tags:        map[string]string{"role": "echo"},
+		},
+		{
+			ServiceName: "registry-multi-hello",
+			Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8894"),
+			Tags:        map[string]string{"role": "hello"},
+		},
+		{
+			ServiceName: "registry-multi-echo",
+			Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8895"),
+			Tags:        map[string]string{"role": "admin"},
+		},
+	}
+	for _, info := range infos {
+		require.Nil(t, rg.Register(info))
+	}
+	registered := rg.ListRegistered()
+	require.Len(t, registered, 3)
+	require.Equal(t, "127.0.0.1:8894", registered[0].Addr.String())
+	require.Equal(t, "echo", registered[0].Tags["role"])
+	require.Equal(t, "127.0.0.1:8895", registered[1].Addr.String())
+	require.Equal(t, "registry-multi-hello", registered[2].ServiceName)
+
+	echo := testServer.Instances(DefaultPolarisNamespace, "registry-multi-echo")
+	require.Len(t, echo, 2)
+	hello := testServer.Instances(DefaultPolarisNamespace, "registry-multi-hello")
+	require.Len(t, hello, 1)
+	require.Equal(t, map[string]string{"env": "test", "role": "hello"}, hello[0].Metadata)
+
+	require.Nil(t, rg.DeregisterAll())
+	require.Empty(t, rg.ListRegistered())
+	require.Empty(t, testServer.Instances(DefaultPolarisNamespace, "registry-multi-echo"))
+	require.Empty(t, testServer.Instances(DefaultPolarisNamespace, "registry-multi-hello"))
+}
+
+func TestHeartbeatBackoff(t *testing.T) {
+	h := HeartbeatOptions{RetryBackoff: 100 * time.Millisecond, MaxRetryBackoff: time.Second}
+	require.Equal(t, 100*time.Millisecond, h.backoff(1, time.Minute))
+	require.Equal(t, 200*time.Millisecond, h.backoff(2, time.Minute))
+	require.Equal(t, 800*time.Millisecond, h.backoff(4, time.Minute))
+	require.Equal(t, time.Second, h.backoff(5, time.Minute))
+	require.Equal(t, time.Second, h.backoff(100, time.Minute))
+	// capped by the heartbeat interval by default
+	h = HeartbeatOptions{}
+	require.Equal(t, time.Second, h.backoff(1, 3*time.Second))
+	require.Equal(t, 3*time.Second, h.backoff(3, 3*time.Second))
+}
+
+func TestRegistration(t *testing.T) {
+	reg, err := newRegistration(nil, ServerOptions{})
+	require.Nil(t, err)
+	require.Equal(t, registration{
+		ttl:              5 * time.Second,
+		registerTimeout:  10 * time.Second,
+		interval:         2500 * time.Millisecond,
+		heartbeatTimeout: 2500 * time.Millisecond,
+	}, reg)
+	for i := 0; i < 100; i++ {
+		next := reg.nextHeartbeat()
+		require.True(t, next >= 2250*time.Millisecond && next <= 2750*time.Millisecond, next)
+	}
+
+	// the tags take precedence over ServerOptions
+	so := ServerOptions{TTL: 10 * time.Second, Heartbeat: HeartbeatOptions{Interval: 3 * time.Second}}
+	info := &registry.Info{Tags: map[string]string{TTLTagKey: "20s", HeartbeatTimeoutTagKey: "2s"}}
+	reg, err = newRegistration(info, so)
+	require.Nil(t, err)
+	require.Equal(t, 20*time.Second, reg.ttl)
+	require.Equal(t, 3*time.Second, reg.interval)
+	require.Equal(t, 2*time.Second, reg.heartbeatTimeout)
+
+	invalid := []ServerOptions{
+		{TTL: 1500 * time.Millisecond},
+		{TTL: -time.Second},
+		{RegisterTimeout: -time.Second},
+		{TTL: 5 * time.Second, Heartbeat: HeartbeatOptions{Interval: 5 * time.Second}},
+		{Heartbeat: HeartbeatOptions{Timeout: 10 * time.Second}},
+	}
+	for _, so := range invalid {
+		_, err = NewPolarisRegistry(so, WithPolarisClient(testClient))
+		require.NotNil(t, err, so)
+	}
+	_, err = newRegistration(&registry.Info{Tags: map[string]string{HeartbeatIntervalTagKey: "soon"}}, ServerOptions{})
+	require.NotNil(t, err)
+
+	// an invalid instance is rejected by Register
+	rg, err := NewPolarisRegistry(ServerOptions{}, WithPolarisClient(testClient))
+	require.Nil(t, err)
+	err = rg.Register(&registry.Info{
+		ServiceName: "registry-ttl",
+		Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8891"),
+		Tags:        map[string]string{HeartbeatIntervalTagKey: "1m"},
+	})
+	require.NotNil(t, err)
+	require.Empty(t, testServer.Instances(DefaultPolarisNamespace, "registry-ttl"))
+}
+
+func TestPolarisRegistryDrain(t *testing.T) {
+	for _, zeroWeight := range []bool{false, true} {
+		var phases []DrainPhase
+		var marked polaristest.Instance
+		so := ServerOptions{Drain: DrainOptions{
+			Enable:          true,
+			ZeroWeight:      zeroWeight,
+			PropagationWait: 200 * time.Millisecond,
+			Hook: func(ctx context.Context, phase DrainPhase, info *registry.Info) error {
+				phases = append(phases, phase)
+				if phase == DrainPhaseWait {
+					marked = testServer.Instances(DefaultPolarisNamespace, info.ServiceName)[0]
+				}
+				return nil
+			},
+		}}
+		rg, err := NewPolarisRegistry(so, WithPolarisClient(testClient))
+		require.Nil(t, err)
+
+		info := &registry.Info{
+			ServiceName: "registry-drain",
+			Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8889"),
+		}
+		require.Nil(t, rg.Register(info))
+		begin := time.Now()
+		require.Nil(t, rg.Deregister(info))
+		require.True(t, time.Since(begin) >= so.Drain.PropagationWait)
+		require.Equal(t, []DrainPhase{DrainPhaseMark, DrainPhaseWait, DrainPhaseDeregister}, phases)
+		if zeroWeight {
+			require.Equal(t, 0, marked.Weight)
+			require.False(t, marked.Isolate)
+		} else {
+			require.True(t, marked.Isolate)
+		}
+		require.Empty(t, testServer.Instances(DefaultPolarisNamespace, info.ServiceName))
+		rg.Destroy()
+	}
+}
@@ -18,8 +18,11 @@ package polaris
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/cloudwego/kitex/pkg/discovery"
 	"github.com/cloudwego/kitex/pkg/rpcinfo"
@@ -36,25 +39,47 @@ const (
 type Resolver interface {
 	discovery.Resolver
 	Watcher(ctx context.Context, desc string) (discovery.Change, error)
+	// StopWatch stops the subscription of desc, pending calls of Watcher return an error.
+	StopWatch(desc string)
+	// Destroy releases the polaris client used by the resolver.
+	Destroy()
 }
 
 // polarisResolver is a resolver using polaris.
 type polarisResolver struct {
-	provider api.ProviderAPI
-	consumer api.ConsumerAPI
-	o        ClientOptions
+	client    *clientRef
+	provider  api.ProviderAPI
+	consumer  api.ConsumerAPI
+	o         ClientOptions
+	lastKnown sync.Map
+
+	watchLock     sync.Mutex
+	subscriptions map[string]*watchSubscription
+}
+
+// resolvedResult is the last result resolved for a description.
+type resolvedResult struct {
+	result   discovery.Result
+	resolved time.Time
 }
 
 // NewPolarisResolver creates a polaris based resolver.
-func NewPolarisResolver(o ClientOptions, configFile ...string) (Resolver, error) {
-	sdkCtx, err := GetPolarisConfig(configFile...)
+func NewPolarisResolver(o ClientOptions, opts ...Option) (Resolver, error) {
+	if err := o.Nearby.validate(); err != nil {
+		return nil, err
+	}
+	if o.Nearby.Enable {
+		o.Nearby.Location = clientLocation(o.Nearby.Location, o.SrcMetadata)
+	}
+	client, err := newClientRef(opts)
 	if err != nil {
 		return nil, err
 	}
 
 	newInstance := &polarisResolver{
-		consumer: api.NewConsumerAPIByContext(sdkCtx),
-		provider: api.NewProviderAPIByContext(sdkCtx),
+		client:   client,
+		consumer: api.NewConsumerAPIByContext(client.SDKContext()),
+		provider: api.NewProviderAPIByContext(client.SDKContext()),
 		o:        o,
 	}
 
@@ -78,73 +103,98 @@ func (pr *polarisResolver) Target(ctx context.Context, target rpcinfo.EndpointIn
 	return serviceIdentification.String()
 }
 
-// Watcher return registered service changes.
+// Watcher returns the change of the instances of desc since the previous call of Watcher,
+// blocking until there is one. All the calls of a resolver share one subscription per desc,
+// changes happening between calls are merged into the next change.
 func (pr *polarisResolver) Watcher(ctx context.Context, desc string) (discovery.Change, error) {
-	var (
-		eps    []discovery.Instance
-		add    []discovery.Instance
-		update []discovery.Instance
-		remove []discovery.Instance
-	)
+	sub, err := pr.subscribe(desc)
+	if err != nil {
+		return discovery.Change{}, err
+	}
+
+	sub.lock.Lock()
+	prev, prevVersion := sub.delivered, sub.version
+	sub.lock.Unlock()
+
+	for {
+		instances, version, changed := sub.watcher.state()
+		if version != prevVersion {
+			next := pr.toResult(desc, instances)
+			sub.lock.Lock()
+			if version > sub.version {
+				sub.delivered, sub.version = next, version
+			}
+			sub.lock.Unlock()
+			return diffResult(desc, prev, next), nil
+		}
+		select {
+		case <-ctx.Done():
+			log.GetBaseLogger().Infof("[Polaris resolver] Watch has been finished")
+			return discovery.Change{}, nil
+		case <-sub.stopped:
+			return discovery.Change{}, fmt.Errorf("watch of %s has been stopped", desc)
+		case <-changed:
+		}
+	}
+}
+
+// subscribe returns the subscription of desc, creating it on the first call.
+func (pr *polarisResolver) subscribe(desc string) (*watchSubscription, error) {
+	pr.watchLock.Lock()
+	defer pr.watchLock.Unlock()
+
+	if sub, ok := pr.subscriptions[desc]; ok {
+		return sub, nil
+	}
 	namespace, serviceName := SplitDescription(desc)
 	key := model.ServiceKey{
 		Namespace: namespace,
 		Service:   serviceName,
 	}
-	watchReq := api.WatchServiceRequest{}
-	watchReq.Key = key
-	watchRsp, err := pr.consumer.WatchService(&watchReq)
-	if nil != err {
-		log.GetBaseLogger().Fatalf("fail to WatchService, err is %v", err)
+	w, err := pr.client.client.watchService(pr.consumer, key)
+	if err != nil {
+		return nil, newPolarisError("WatchService", desc, err)
+	}
+	instances, version, _ := w.state()
+	sub := &watchSubscription{
+		watcher:   w,
+		stopped:   make(chan struct{}),
+		version:   version,
+		delivered: pr.toResult(desc, instances),
 	}
-	instances := watchRsp.GetAllInstancesResp.Instances
+	if pr.subscriptions == nil {
+		pr.subscriptions = make(map[string]*watchSubscription)
+	}
+	pr.subscriptions[desc] = sub
+	return sub, nil
+}
 
-	if nil != instances {
-		for _, instance := range instances {
-			log.GetBaseLogger().Infof("instance getOneInstance is %s:%d", instance.GetHost(), instance.GetPort())
-			eps = append(eps, ChangePolarisInstanceToKitex(instance, pr.o))
-		}
+// StopWatch implements the Resolver interface.
+func (pr *polarisResolver) StopWatch(desc string) {
+	pr.watchLock.Lock()
+	defer pr.watchLock.Unlock()
+
+	if sub, ok := pr.subscriptions[desc]; ok {
+		delete(pr.subscriptions, desc)
+		pr.stopSubscription(sub)
 	}
+}
 
-	result := discovery.Result{
+func (pr *polarisResolver) stopSubscription(sub *watchSubscription) {
+	close(sub.stopped)
+	pr.client.client.unwatchService(sub.watcher)
+}
+
+func (pr *polarisResolver) toResult(desc string, instances []model.Instance) discovery.Result {
+	eps := make([]discovery.Instance, 0, len(instances))
+	for _, instance := range instances {
+		eps = append(eps, ChangePolarisInstanceToKitex(instance, pr.o))
+	}
+	return discovery.Result{
 		Cacheable: true,
 		CacheKey:  desc,
 		Instances: eps,
 	}
-	Change := discovery.Change{}
-
-	select {
-	case <-ctx.Done():
-		log.GetBaseLogger().Infof("[Polaris resolver] Watch has been finished")
-		return Change, nil
-	case event := <-watchRsp.EventChannel:
-		eType := event.GetSubScribeEventType()
-		if eType == api.EventInstance {
-			insEvent := event.(*model.InstanceEvent)
-			if insEvent.AddEvent != nil {
-				for _, instance := range insEvent.AddEvent.Instances {
-					add = append(add, ChangePolarisInstanceToKitex(instance, pr.o))
-				}
-			}
-			if insEvent.UpdateEvent != nil {
-				for i := range insEvent.UpdateEvent.UpdateList {
-					update = append(update, ChangePolarisInstanceToKitex(insEvent.UpdateEvent.UpdateList[i].After, pr.o))
-				}
-			}
-			if insEvent.DeleteEvent != nil {
-				for _, instance := range insEvent.DeleteEvent.Instances {
-					remove = append(remove, ChangePolarisInstanceToKitex(instance, pr.o))
-				}
-			}
-			Change = discovery.Change{
-				Result:  result,
-				Added:   add,
-				Updated: update,
-				Removed: remove,
-			}
-		}
-		return Change, nil
-	}
 }
 
 // Resolve implements the Resolver interface.
@@ -156,7 +206,12 @@ func (pr *polarisResolver) Resolve(ctx context.Context, desc string) (discovery.
 	getInstances.Service = serviceName
 	InstanceResp, err := pr.consumer.GetInstances(getInstances)
 	if nil != err {
-		log.GetBaseLogger().Fatalf("fail to getOneInstance, err is %v", err)
+		pErr := newPolarisError("GetInstances", desc, err)
+		if result, ok := pr.staleResult(desc, pErr); ok {
+			log.GetBaseLogger().Warnf("serve last known instances of %s, err is %v", desc, pErr)
+			return result, nil
+		}
+		return discovery.Result{}, pErr
 	}
 	instances := InstanceResp.GetInstances()
 	if nil != instances {
@@ -167,13 +222,38 @@ func (pr *polarisResolver) Resolve(ctx context.Context, desc string) (discovery.
 	}
 
 	if len(eps) == 0 {
+		pr.lastKnown.Delete(desc)
 		return discovery.Result{}, fmt.Errorf("no instance remains for %s", desc)
 	}
-	return discovery.Result{
+	result := discovery.Result{
 		Cacheable: true,
 		CacheKey:  desc,
 		Instances: eps,
-	}, nil
+	}
+	if pr.o.ServeStaleOnError {
+		pr.lastKnown.Store(desc, &resolvedResult{result: result, resolved: time.Now()})
+	}
+	return result, nil
+}
+
+// staleResult returns the last known result of desc if the options allow to serve it for err.
+func (pr *polarisResolver) staleResult(desc string, err error) (discovery.Result, bool) {
+	if errors.Is(err, ErrServiceNotFound) {
+		pr.lastKnown.Delete(desc)
+		return discovery.Result{}, false
+	}
+	if !pr.o.ServeStaleOnError || !errors.Is(err, ErrControlPlaneUnavailable) {
+		return discovery.Result{}, false
+	}
+	v, ok := pr.lastKnown.Load(desc)
+	if !ok {
+		return discovery.Result{}, false
+	}
+	last := v.(*resolvedResult)
+	if pr.o.StaleMaxAge > 0 && time.Since(last.resolved) > pr.o.StaleMaxAge {
+		return discovery.Result{}, false
+	}
+	return last.result, true
 }
 
 // Diff implements the Resolver interface.
@@ -181,6 +261,17 @@ func (pr *polarisResolver) Diff(cacheKey string, prev, next discovery.Result) (d
 	return discovery.DefaultDiff(cacheKey, prev, next)
 }
 
+// Destroy implements the Resolver interface.
+func (pr *polarisResolver) Destroy() {
+	pr.watchLock.Lock()
+	for desc, sub := range pr.subscriptions {
+		delete(pr.subscriptions, desc)
+		pr.stopSubscription(sub)
+	}
+	pr.watchLock.Unlock()
+	pr.client.Destroy()
+}
+
 // Name implements the Resolver interface.
 func (pr *polarisResolver) Name() string {
 	return "Polaris"
@@ -18,13 +18,23 @@ package polaris
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/loadbalance"
 	"github.com/cloudwego/kitex/pkg/registry"
 	"github.com/cloudwego/kitex/pkg/rpcinfo"
 	"github.com/cloudwego/kitex/pkg/utils"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/kitex-contrib/polaris/polaristest"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	"github.com/polarismesh/polaris-go/pkg/model/local"
+	"github.com/polarismesh/polaris-go/pkg/model/pb"
+	namingpb "github.com/polarismesh/polaris-go/pkg/model/pb/v1"
 	"github.com/stretchr/testify/require"
 )
 
@@ -34,11 +44,11 @@ const (
 
 func TestPolarisResolver(t *testing.T) {
 	so := ServerOptions{}
-	rg, err := NewPolarisRegistry(so)
+	rg, err := NewPolarisRegistry(so, WithPolarisClient(testClient))
 	require.Nil(t, err)
 
 	co := ClientOptions{}
-	rs, err := NewPolarisResolver(co)
+	rs, err := NewPolarisResolver(co, WithPolarisClient(testClient))
 	require.Nil(t, err)
 
 	// test register service
@@ -50,7 +60,7 @@ func TestPolarisResolver(t *testing.T) {
 	}
 	err = rg.Register(InstanceOne)
 	require.Nil(t, err)
-	time.Sleep(15 * time.Second)                                                          // wait register service
+	require.Len(t, testServer.Instances(DefaultPolarisNamespace, serviceName), 1)
 	desc := rs.Target(context.TODO(), rpcinfo.NewEndpointInfo(serviceName, "", nil, nil)) // the namespace is default
 	result, err := rs.Resolve(context.TODO(), desc)
 	require.Nil(t, err)
@@ -63,47 +73,267 @@ func TestPolarisResolver(t *testing.T) {
 			}),
 		},
 	}
+	require.Equal(t, expected.CacheKey, result.CacheKey)
 	require.Equal(t, len(expected.Instances), len(result.Instances))
 	for i := 0; i < len(expected.Instances); i++ {
 		require.Equal(t, expected.Instances[i].Address(), result.Instances[i].Address())
 	}
-	watcherChange, err := rs.Watcher(context.TODO(), desc)
-	require.Nil(t, err)
-	t.Logf("the number of instance is %d", len(watcherChange.Result.Instances))
 
-	// test register service
+	// test watch the register of a new instance
 	InstanceTwo := &registry.Info{
 		ServiceName: serviceName,
 		Addr:        utils.NewNetAddr("tcp", "127.0.0.1:7777"),
 		Weight:      100,
 		Tags:        nil, // namespace is default
 	}
-	err = rg.Register(InstanceTwo)
-	require.Nil(t, err)
-	time.Sleep(15 * time.Second) // wait register service
-	watcherChange, err = rs.Watcher(context.TODO(), desc)
+	go func() {
+		time.Sleep(polaristest.RefreshInterval)
+		testServer.AddInstance(DefaultPolarisNamespace, serviceName, polaristest.Instance{Host: "127.0.0.1", Port: 7777})
+	}()
+	watcherChange, err := rs.Watcher(context.TODO(), desc)
 	require.Nil(t, err)
-	t.Logf("the number of instance is %d", len(watcherChange.Result.Instances))
+	require.Len(t, watcherChange.Added, 1)
+	require.Equal(t, InstanceTwo.Addr.String(), watcherChange.Added[0].Address().String())
 	result, err = rs.Resolve(context.TODO(), desc)
 	require.Nil(t, err)
+	require.Len(t, result.Instances, 2)
 
 	// test deregister service
-
+	err = rg.Register(InstanceTwo)
+	require.Nil(t, err)
 	err = rg.Deregister(InstanceOne) // deregister InstanceOne
 	require.Nil(t, err)
 	err = rg.Deregister(InstanceTwo) // deregister InstanceTwo
 	require.Nil(t, err)
-	time.Sleep(15 * time.Second) // wait deregister service
-	watcherChange, err = rs.Watcher(context.TODO(), desc)
-	require.Nil(t, err)
-	t.Logf("the number of instance is %d", len(watcherChange.Result.Instances))
-	desc = rs.Target(context.TODO(), rpcinfo.NewEndpointInfo(serviceName, "", nil, nil)) // namespace is  default
-	result, err = rs.Resolve(context.TODO(), desc)
-	require.NotNil(t, err)
+	require.Empty(t, testServer.Instances(DefaultPolarisNamespace, serviceName))
+	require.Eventually(t, func() bool {
+		_, err := rs.Resolve(context.TODO(), desc)
+		return err != nil
+	}, polaristest.SyncTimeout, polaristest.RefreshInterval)
+}
+
+func TestResolveInstanceTags(t *testing.T) {
+	svcName := "resolver-tags"
+	id := testServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{
+		Host:     "127.0.0.1",
+		Port:     9310,
+		Protocol: "tcp",
+		Version:  "1.0.0",
+		Priority: 1,
+		Region:   "south",
+		Zone:     "sz",
+		Metadata: map[string]string{"env": "test", "version": "meta"},
+	})
+
+	for _, prefix := range []string{"", "meta."} {
+		rs, err := NewPolarisResolver(ClientOptions{MetadataTagPrefix: prefix}, WithPolarisClient(testClient))
+		require.Nil(t, err)
+		desc := rs.Target(context.TODO(), rpcinfo.NewEndpointInfo(svcName, "", nil, nil))
+		result, err := rs.Resolve(context.TODO(), desc)
+		require.Nil(t, err)
+		require.Len(t, result.Instances, 1)
+		ins := result.Instances[0]
+
+		expected := map[string]string{
+			NameSpaceTagKey:  DefaultPolarisNamespace,
+			InstanceIDTagKey: id,
+			ProtocolTagKey:   "tcp",
+			VersionTagKey:    "1.0.0",
+			PriorityTagKey:   "1",
+			RegionTagKey:     "south",
+			ZoneTagKey:       "sz",
+			HealthyTagKey:    "true",
+			IsolateTagKey:    "false",
+			prefix + "env":   "test",
+		}
+		for key, value := range expected {
+			tag, ok := ins.Tag(key)
+			require.True(t, ok, key)
+			require.Equal(t, value, tag, key)
+		}
+		_, ok := ins.Tag(CampusTagKey)
+		require.False(t, ok)
+		// the attributes take precedence over the metadata without prefix
+		tag, _ := ins.Tag(prefix + "version")
+		if prefix == "" {
+			require.Equal(t, "1.0.0", tag)
+		} else {
+			require.Equal(t, "meta", tag)
+			_, ok = ins.Tag("env")
+			require.False(t, ok)
+		}
+		rs.Destroy()
+	}
+}
+
+func TestZeroWeightInstances(t *testing.T) {
+	newInstance := func(port, weight int) model.Instance {
+		return pb.NewInstanceInProto(&namingpb.Instance{
+			Id:      &wrappers.StringValue{Value: fmt.Sprint(port)},
+			Host:    &wrappers.StringValue{Value: "127.0.0.1"},
+			Port:    &wrappers.UInt32Value{Value: uint32(port)},
+			Weight:  &wrappers.UInt32Value{Value: uint32(weight)},
+			Healthy: &wrappers.BoolValue{Value: true},
+		}, &model.ServiceKey{Namespace: DefaultPolarisNamespace, Service: "zero-weight"}, local.NewInstanceLocalValue())
+	}
+	drained, serving := newInstance(9321, 0), newInstance(9322, 100)
+	require.Equal(t, 0, ChangePolarisInstanceToKitex(drained, ClientOptions{}).Weight())
+
+	result := discovery.Result{
+		Cacheable: true,
+		CacheKey:  "polaris:" + DefaultPolarisNamespace + ":zero-weight",
+		Instances: []discovery.Instance{
+			ChangePolarisInstanceToKitex(drained, ClientOptions{}),
+			ChangePolarisInstanceToKitex(serving, ClientOptions{}),
+		},
+	}
+	ctx := newRPCInfoCtx("zero-weight", "Echo")
+	for _, lb := range []loadbalance.Loadbalancer{
+		loadbalance.NewWeightedBalancer(),
+		NewConsistentHashBalancer(ConsistentHashOptions{Key: HashKeyByMethod}),
+	} {
+		for i := 0; i < 10; i++ {
+			require.Equal(t, "127.0.0.1:9322", lb.GetPicker(result).Next(ctx, nil).Address().String(), lb.Name())
+		}
+	}
+
+	var wrr weightedRoundRobin
+	for i := 0; i < 10; i++ {
+		require.Equal(t, serving, wrr.next([]model.Instance{drained, serving}))
+	}
+	require.Nil(t, wrr.next([]model.Instance{drained}))
 }
 
 func TestEmptyEndpoints(t *testing.T) {
 	co := ClientOptions{}
-	_, err := NewPolarisResolver(co)
+	_, err := NewPolarisResolver(co, WithPolarisClient(testClient))
+	require.Nil(t, err)
+}
+
+// unavailableConsumer fails every GetInstances request like an unreachable polaris.
+type unavailableConsumer struct {
+	api.ConsumerAPI
+}
+
+func (c *unavailableConsumer) GetInstances(req *api.GetInstancesRequest) (*model.InstancesResponse, error) {
+	return nil, model.NewSDKError(model.ErrCodeNetworkError, nil, "connection refused")
+}
+
+// notFoundConsumer fails every GetInstances request like a polaris which doesn't know the service.
+type notFoundConsumer struct {
+	api.ConsumerAPI
+}
+
+func (c *notFoundConsumer) GetInstances(req *api.GetInstancesRequest) (*model.InstancesResponse, error) {
+	return nil, model.NewServerSDKError(namingpb.NotFoundResource, "not found resource", nil, "fail to discover")
+}
+
+func TestResolveServiceNotFound(t *testing.T) {
+	rs, err := NewPolarisResolver(ClientOptions{}, WithPolarisClient(testClient))
+	require.Nil(t, err)
+	defer rs.Destroy()
+
+	pr := rs.(*polarisResolver)
+	pr.consumer = &notFoundConsumer{ConsumerAPI: pr.consumer}
+	desc := rs.Target(context.TODO(), rpcinfo.NewEndpointInfo("resolver-not-found", "", nil, nil))
+	_, err = rs.Resolve(context.TODO(), desc)
+	require.True(t, errors.Is(err, ErrServiceNotFound))
+	require.False(t, errors.Is(err, ErrControlPlaneUnavailable))
+}
+
+func TestResolveServeStale(t *testing.T) {
+	svcName := "resolver-stale"
+	testServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{Host: "127.0.0.1", Port: 9300})
+
+	co := ClientOptions{ServeStaleOnError: true, StaleMaxAge: time.Minute}
+	rs, err := NewPolarisResolver(co, WithPolarisClient(testClient))
+	require.Nil(t, err)
+	defer rs.Destroy()
+	desc := rs.Target(context.TODO(), rpcinfo.NewEndpointInfo(svcName, "", nil, nil))
+	expected, err := rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+
+	pr := rs.(*polarisResolver)
+	pr.consumer = &unavailableConsumer{ConsumerAPI: pr.consumer}
+	result, err := rs.Resolve(context.TODO(), desc)
 	require.Nil(t, err)
+	require.Equal(t, expected, result)
+
+	// the last known instances are too old
+	pr.o.StaleMaxAge = time.Nanosecond
+	_, err = rs.Resolve(context.TODO(), desc)
+	require.True(t, errors.Is(err, ErrControlPlaneUnavailable))
+
+	// serving stale instances is disabled
+	pr.o.ServeStaleOnError = false
+	pr.o.StaleMaxAge = 0
+	_, err = rs.Resolve(context.TODO(), desc)
+	require.True(t, errors.Is(err, ErrControlPlaneUnavailable))
+	var pErr *PolarisError
+	require.True(t, errors.As(err, &pErr))
+	require.Equal(t, desc, pErr.Desc)
+}
+
+func TestPolarisResolverWatch(t *testing.T) {
+	svcName := "resolver-watch"
+	testServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{Host: "127.0.0.1", Port: 9400})
+
+	rs, err := NewPolarisResolver(ClientOptions{}, WithPolarisClient(testClient))
+	require.Nil(t, err)
+	defer rs.Destroy()
+	other, err := NewPolarisResolver(ClientOptions{}, WithPolarisClient(testClient))
+	require.Nil(t, err)
+	defer other.Destroy()
+	desc := rs.Target(context.TODO(), rpcinfo.NewEndpointInfo(svcName, "", nil, nil))
+
+	// resolvers of a client share one subscription
+	pr, po := rs.(*polarisResolver), other.(*polarisResolver)
+	subA, err := pr.subscribe(desc)
+	require.Nil(t, err)
+	subB, err := po.subscribe(desc)
+	require.Nil(t, err)
+	require.Same(t, subA.watcher, subB.watcher)
+
+	// changes happening between calls are merged into the next change
+	testServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{Host: "127.0.0.1", Port: 9401})
+	testServer.AddInstance(DefaultPolarisNamespace, svcName, polaristest.Instance{Host: "127.0.0.1", Port: 9402})
+	testServer.RemoveInstance(DefaultPolarisNamespace, svcName, "127.0.0.1", 9400)
+	added, removed := map[string]bool{}, map[string]bool{}
+	require.Eventually(t, func() bool {
+		ctx, cancel := context.WithTimeout(context.Background(), polaristest.RefreshInterval)
+		defer cancel()
+		change, err := rs.Watcher(ctx, desc)
+		require.Nil(t, err)
+		for _, ins := range change.Added {
+			added[ins.Address().String()] = true
+		}
+		for _, ins := range change.Removed {
+			removed[ins.Address().String()] = true
+		}
+		return len(added) == 2 && len(removed) == 1
+	}, polaristest.SyncTimeout, polaristest.RefreshInterval)
+	require.True(t, added["127.0.0.1:9401"] && added["127.0.0.1:9402"] && removed["127.0.0.1:9400"])
+
+	// the other resolver sees the whole change at once
+	change, err := other.Watcher(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Len(t, change.Added, 2)
+	require.Len(t, change.Removed, 1)
+	require.Len(t, change.Result.Instances, 2)
+
+	// stopping the watch releases pending callers
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := other.Watcher(context.TODO(), desc)
+		errCh <- err
+	}()
+	time.Sleep(polaristest.RefreshInterval)
+	other.StopWatch(desc)
+	require.NotNil(t, <-errCh)
+
+	rs.StopWatch(desc)
+	testClient.watchLock.Lock()
+	_, ok := testClient.watchers[model.ServiceKey{Namespace: DefaultPolarisNamespace, Service: svcName}]
+	testClient.watchLock.Unlock()
+	require.False(t, ok)
 }
@@ -0,0 +1,90 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"sync"
+
+	"github.com/cloudwego/kitex/pkg/stats"
+)
+
+// ExcludedFallback decides what the picker does when every routed instance has already been tried in the RPC.
+type ExcludedFallback int
+
+const (
+	// ExcludedFallbackAll picks among all the routed instances again.
+	ExcludedFallbackAll ExcludedFallback = iota
+	// ExcludedFallbackFail picks no instance, the RPC fails with ErrAllInstancesTried.
+	ExcludedFallbackFail
+)
+
+// triedKey is the context key of the triedInstances set by RetryTracer.
+type triedKey struct{}
+
+// triedInstances are the polaris ids of the instances picked in an RPC, shared by its retries.
+type triedInstances struct {
+	lock sync.Mutex
+	ids  []string
+}
+
+func (t *triedInstances) add(id string) {
+	t.lock.Lock()
+	t.ids = append(t.ids, id)
+	t.lock.Unlock()
+}
+
+// snapshot returns a copy of the ids, nil if there is none.
+func (t *triedInstances) snapshot() []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(t.ids) == 0 {
+		return nil
+	}
+	return append([]string(nil), t.ids...)
+}
+
+// RetryTracer lets the polaris picker exclude the instances tried by the previous attempts of an RPC,
+// add it with client.WithTracer when the client retries, or set ClientSuite.ExcludeTriedOnRetry.
+// Without it only the instances tried within an attempt are excluded.
+// Like any tracer, it makes Kitex default the stats level to stats.LevelDetailed, set client.WithStatsLevel to keep it lower,
+// the tracer works at any level.
+type RetryTracer struct{}
+
+var _ stats.Tracer = RetryTracer{}
+
+// Start implements the stats.Tracer interface.
+func (RetryTracer) Start(ctx context.Context) context.Context {
+	return context.WithValue(ctx, triedKey{}, &triedInstances{})
+}
+
+// Finish implements the stats.Tracer interface.
+func (RetryTracer) Finish(ctx context.Context) {}
+
+func getTriedInstances(ctx context.Context) *triedInstances {
+	t, _ := ctx.Value(triedKey{}).(*triedInstances)
+	return t
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	polarisgo "github.com/polarismesh/polaris-go"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+const (
+	defaultRouteCacheTTL        = time.Second
+	defaultRouteCacheMaxEntries = 1024
+)
+
+// RouteCacheOptions configures the cache of the routed instances, which saves routing every request.
+// The routed instances are cached by the source labels and the method of the requests,
+// until the routing rules or the instances change, or TTL elapses, which bounds how long
+// the routed instances miss the changes of the circuit breakers.
+type RouteCacheOptions struct {
+	Disable bool
+	// TTL is how long the routed instances are cached, 1s if zero.
+	TTL time.Duration
+	// MaxEntries is the largest number of source labels and method combinations cached per service, 1024 if zero.
+	// The expired combinations make room for the new ones, which are routed every time until then.
+	MaxEntries int
+}
+
+func (ro RouteCacheOptions) ttl() time.Duration {
+	if ro.TTL <= 0 {
+		return defaultRouteCacheTTL
+	}
+	return ro.TTL
+}
+
+func (ro RouteCacheOptions) maxEntries() int32 {
+	if ro.MaxEntries <= 0 {
+		return defaultRouteCacheMaxEntries
+	}
+	return int32(ro.MaxEntries)
+}
+
+// routeCache caches the routed instances of a polarisInfo, it is replaced with the instances.
+type routeCache struct {
+	entries sync.Map // route key => *routeEntry
+	size    int32
+	// sweepAfter is when the earliest cached entry expires, a full cache is swept no earlier (unix nano).
+	sweepAfter int64
+	sweepLock  sync.Mutex
+}
+
+// routeEntry holds the routed instances as a ServiceInstances shared by the pickers of the route, so the
+// clusters and the hash rings the SDK caches in it are reused. It is not the response of ProcessRouters,
+// which carries the cluster the load balancers write to, each load balancing builds its own cluster of it instead.
+type routeEntry struct {
+	instances    model.ServiceInstances
+	ruleRevision string
+	expire       int64 // unix nano
+}
+
+// get returns the routed instances of key if they were routed by the rules of ruleRevision and have not expired.
+func (c *routeCache) get(key, ruleRevision string, now time.Time) (model.ServiceInstances, bool) {
+	v, ok := c.entries.Load(key)
+	if !ok {
+		return nil, false
+	}
+	entry := v.(*routeEntry)
+	if entry.ruleRevision != ruleRevision || now.UnixNano() >= entry.expire {
+		return nil, false
+	}
+	return entry.instances, true
+}
+
+// put caches the routed instances of key, the expired entries make room for it when the cache is full.
+// key is not cached if the cache is still full.
+func (c *routeCache) put(key string, entry *routeEntry, maxEntries int32, now time.Time) {
+	if _, ok := c.entries.Load(key); ok {
+		c.entries.Store(key, entry)
+		return
+	}
+	if atomic.AddInt32(&c.size, 1) > maxEntries {
+		atomic.AddInt32(&c.size, -1)
+		if !c.sweep(now, entry.expire) {
+			return
+		}
+		if atomic.AddInt32(&c.size, 1) > maxEntries {
+			atomic.AddInt32(&c.size, -1)
+			return
+		}
+	}
+	if _, loaded := c.entries.LoadOrStore(key, entry); loaded {
+		atomic.AddInt32(&c.size, -1)
+		c.entries.Store(key, entry)
+	}
+}
+
+// sweep deletes the expired entries, it returns whether any is deleted.
+// The entries cached later expire no earlier than next, as they are cached with the same TTL.
+func (c *routeCache) sweep(now time.Time, next int64) bool {
+	nowNano := now.UnixNano()
+	if nowNano < atomic.LoadInt64(&c.sweepAfter) {
+		return false
+	}
+	c.sweepLock.Lock()
+	defer c.sweepLock.Unlock()
+	if nowNano < atomic.LoadInt64(&c.sweepAfter) {
+		return false
+	}
+	swept := false
+	earliest := next
+	c.entries.Range(func(k, v interface{}) bool {
+		expire := v.(*routeEntry).expire
+		if nowNano < expire {
+			if expire < earliest {
+				earliest = expire
+			}
+			return true
+		}
+		// an entry stored meanwhile is deleted too, it is merely routed again
+		if _, ok := c.entries.LoadAndDelete(k); ok {
+			atomic.AddInt32(&c.size, -1)
+			swept = true
+		}
+		return true
+	})
+	atomic.StoreInt64(&c.sweepAfter, earliest)
+	return swept
+}
+
+// routeKey identifies the requests routed alike, by their method and source labels.
+func routeKey(method string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return method
+	}
+	keys := make([]string, 0, len(labels))
+	size := len(method)
+	for k, v := range labels {
+		keys = append(keys, k)
+		size += len(k) + len(v) + 2
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.Grow(size)
+	b.WriteString(method)
+	for _, k := range keys {
+		b.WriteByte(0)
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(labels[k])
+	}
+	return b.String()
+}
+
+// routeRuleRevision returns the revisions of the routing rules of the callee and of the caller,
+// which the SDK serves from its cache once ProcessRouters loaded them.
+func routeRuleRevision(consumer polarisgo.ConsumerAPI, dst, src model.ServiceKey) string {
+	revision := routeRevision(consumer, dst)
+	if src.Service == "" {
+		return revision
+	}
+	return revision + "/" + routeRevision(consumer, src)
+}
+
+// routeRevision returns the revision of the routing rule of svc, empty if it can't be loaded.
+func routeRevision(consumer polarisgo.ConsumerAPI, svc model.ServiceKey) string {
+	req := &polarisgo.GetServiceRuleRequest{}
+	req.Namespace = svc.Namespace
+	req.Service = svc.Service
+	rule, err := consumer.GetRouteRule(req)
+	if err != nil {
+		return ""
+	}
+	return rule.GetRevision()
+}
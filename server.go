@@ -16,6 +16,179 @@
 
 package polaris
 
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/endpoint"
+	"github.com/cloudwego/kitex/pkg/registry"
+	"github.com/cloudwego/kitex/server"
+)
+
 type ServerOptions struct {
+	// Namespace is the namespace the service is registered in, DefaultPolarisNamespace if empty.
+	// The namespace tag of registry.Info takes precedence over it.
+	Namespace string
+	// Metadata is registered with every instance, merged with the tags of registry.Info which win over it.
+	// The namespace, duration and attribute tags, such as VersionTagKey, are not metadata.
 	Metadata map[string]string
+	// TTL is how long polaris keeps an instance without heartbeat, in whole seconds, 5s if zero.
+	// The TTLTagKey tag of registry.Info takes precedence over it.
+	TTL time.Duration
+	// RegisterTimeout is the timeout of the register and deregister requests, 10s if zero.
+	// The RegisterTimeoutTagKey tag of registry.Info takes precedence over it.
+	RegisterTimeout time.Duration
+	// Address selects the registered address when the host of registry.Info is empty or unspecified.
+	Address AddressOptions
+	// Drain configures how the instances are drained before they are deregistered.
+	Drain DrainOptions
+	// Heartbeat configures how failed heartbeats are retried and reported.
+	Heartbeat HeartbeatOptions
+}
+
+// DrainPhase is a phase of the drain of an instance.
+type DrainPhase int
+
+const (
+	// DrainPhaseMark isolates the instance or sets its weight to 0, so the clients stop picking it.
+	DrainPhaseMark DrainPhase = iota
+	// DrainPhaseWait gives the clients time to notice the change while the in-flight requests finish.
+	DrainPhaseWait
+	// DrainPhaseDeregister deregisters the instance.
+	DrainPhaseDeregister
+)
+
+// DrainHook is called at the beginning of each phase of a drain, ctx is done when the phase times out.
+// An error is logged and doesn't stop the drain.
+type DrainHook func(ctx context.Context, phase DrainPhase, info *registry.Info) error
+
+// DrainOptions configures the drain done by Deregister, the instance is deregistered at once if it isn't enabled.
+type DrainOptions struct {
+	Enable bool
+	// ZeroWeight sets the weight of the instance to 0 instead of isolating it.
+	ZeroWeight bool
+	// MarkTimeout is the timeout of DrainPhaseMark, the register timeout of the instance if zero.
+	MarkTimeout time.Duration
+	// PropagationWait is the duration of DrainPhaseWait.
+	PropagationWait time.Duration
+	// DeregisterTimeout is the timeout of DrainPhaseDeregister, the register timeout of the instance if zero.
+	DeregisterTimeout time.Duration
+	Hook              DrainHook
+}
+
+// RegistrationState is the registration health of an instance reported by a RegistrationEvent.
+type RegistrationState int
+
+const (
+	// RegistrationHealthy means the heartbeats of the instance succeed again after failures.
+	RegistrationHealthy RegistrationState = iota
+	// RegistrationHeartbeatFailed means a heartbeat failed, it is retried with an exponential backoff.
+	RegistrationHeartbeatFailed
+	// RegistrationReregistered means polaris lost the instance, which has been registered again.
+	RegistrationReregistered
+	// RegistrationReregisterFailed means polaris lost the instance and registering it again failed.
+	RegistrationReregisterFailed
+)
+
+// RegistrationEvent reports a change of the registration health of an instance.
+type RegistrationEvent struct {
+	Namespace string
+	Service   string
+	Host      string
+	Port      int
+	State     RegistrationState
+	// Failures is the number of consecutive failed heartbeats.
+	Failures int
+	// Err is the error of the failed heartbeat or registration.
+	Err error
+}
+
+// HeartbeatOptions configures the heartbeats of the registered instances.
+// An instance lost by polaris, because it expired or the server restarted, is registered again.
+type HeartbeatOptions struct {
+	// Interval is the mean delay between two heartbeats, jittered by 10%, half the TTL if zero.
+	// It must be shorter than the TTL, the HeartbeatIntervalTagKey tag of registry.Info takes precedence over it.
+	Interval time.Duration
+	// Timeout is the timeout of a heartbeat request, the interval if zero.
+	// It must be shorter than the TTL, the HeartbeatTimeoutTagKey tag of registry.Info takes precedence over it.
+	Timeout time.Duration
+	// RetryBackoff is the delay before retrying a failed heartbeat, doubled after each failure, 1s if zero.
+	RetryBackoff time.Duration
+	// MaxRetryBackoff caps the retry delay, the heartbeat interval if zero.
+	MaxRetryBackoff time.Duration
+	// OnEvent is called by the heartbeat goroutine of the instance, it must not block.
+	OnEvent func(event RegistrationEvent)
+}
+
+func (so ServerOptions) namespace() string {
+	if len(so.Namespace) == 0 {
+		return DefaultPolarisNamespace
+	}
+	return so.Namespace
+}
+
+// ServerSuite It is used to assemble multiple associated server's Options
+type ServerSuite struct {
+	ServerOptions    ServerOptions       // namespace and metadata of the registered instance
+	Registry         registry.Registry   // service registry component
+	RateLimitMW      endpoint.Middleware // rate limit the requests of each method
+	RateLimitOptions RateLimitOptions    // labels of the default rate limit middleware, in the namespace of ServerOptions if not set
+	DeregisterWait   time.Duration       // time for the clients to notice the deregistration before the server stops
+	PolarisOptions   []Option            // options to create the default components with
+}
+
+func NewDefaultServerSuite() *ServerSuite {
+	return &ServerSuite{}
+}
+
+// Options implements the server.Suite interface.
+func (ss *ServerSuite) Options() []server.Option {
+	var opts []server.Option
+
+	r := &suiteRegistry{wait: ss.DeregisterWait}
+	if ss.Registry != nil {
+		r.Registry = ss.Registry
+	} else {
+		pr, err := NewPolarisRegistry(ss.ServerOptions, ss.PolarisOptions...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		r.Registry = pr
+		r.owned = pr
+	}
+	opts = append(opts, server.WithRegistry(r))
+
+	if ss.RateLimitMW != nil {
+		opts = append(opts, server.WithMiddleware(ss.RateLimitMW))
+	} else {
+		ro := ss.RateLimitOptions
+		if len(ro.Namespace) == 0 {
+			ro.Namespace = ss.ServerOptions.namespace()
+		}
+		opts = append(opts, server.WithMiddleware(NewRateLimitMW(ro, ss.PolarisOptions...)))
+	}
+
+	return opts
+}
+
+// suiteRegistry hooks the deregistration done by the Kitex server when it stops.
+type suiteRegistry struct {
+	registry.Registry
+	wait time.Duration
+	// owned is the registry created by the suite, released with its last registration.
+	owned Registry
+}
+
+// Deregister deregisters the server, then waits for the clients to stop sending requests to it
+// and releases the registry created by the suite once nothing remains registered by it.
+func (r *suiteRegistry) Deregister(info *registry.Info) error {
+	err := r.Registry.Deregister(info)
+	if err == nil && r.wait > 0 {
+		time.Sleep(r.wait)
+	}
+	if r.owned != nil && len(r.owned.ListRegistered()) == 0 {
+		r.owned.Destroy()
+	}
+	return err
 }
@@ -0,0 +1,85 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/generic"
+	"github.com/cloudwego/kitex/pkg/registry"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/cloudwego/kitex/pkg/utils"
+	"github.com/cloudwego/kitex/server"
+	"github.com/cloudwego/kitex/server/genericserver"
+	"github.com/kitex-contrib/polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+type genericHandler struct{}
+
+func (*genericHandler) GenericCall(ctx context.Context, method string, request interface{}) (interface{}, error) {
+	return request, nil
+}
+
+func TestServerSuite(t *testing.T) {
+	svcName := "server-suite"
+	ss := &ServerSuite{
+		ServerOptions:  ServerOptions{Namespace: "suite", Metadata: map[string]string{"env": "test"}},
+		DeregisterWait: 200 * time.Millisecond,
+		PolarisOptions: []Option{WithPolarisClient(testClient)},
+	}
+	svr := genericserver.NewServer(&genericHandler{}, generic.BinaryThriftGeneric(),
+		server.WithSuite(ss),
+		server.WithServerBasicInfo(&rpcinfo.EndpointBasicInfo{ServiceName: svcName}),
+		server.WithServiceAddr(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9600}),
+		server.WithExitWaitTime(time.Millisecond),
+	)
+	go svr.Run()
+
+	require.Eventually(t, func() bool {
+		return len(testServer.Instances("suite", svcName)) == 1
+	}, polaristest.SyncTimeout, polaristest.RefreshInterval)
+	require.Equal(t, "test", testServer.Instances("suite", svcName)[0].Metadata["env"])
+
+	begin := time.Now()
+	require.Nil(t, svr.Stop())
+	require.True(t, time.Since(begin) >= ss.DeregisterWait)
+	require.Empty(t, testServer.Instances("suite", svcName))
+}
+
+func TestSuiteRegistryDestroy(t *testing.T) {
+	pr, err := NewPolarisRegistry(ServerOptions{}, WithPolarisClient(testClient))
+	require.Nil(t, err)
+	r := &suiteRegistry{Registry: pr, owned: pr}
+
+	infos := []*registry.Info{
+		{ServiceName: "suite-registry-a", Addr: utils.NewNetAddr("tcp", "127.0.0.1:9601")},
+		{ServiceName: "suite-registry-b", Addr: utils.NewNetAddr("tcp", "127.0.0.1:9602")},
+	}
+	for _, info := range infos {
+		require.Nil(t, r.Register(info))
+	}
+	// the registry is kept for the other registration
+	require.Nil(t, r.Deregister(infos[0]))
+	require.Len(t, pr.ListRegistered(), 1)
+	require.Len(t, testServer.Instances(DefaultPolarisNamespace, "suite-registry-b"), 1)
+	require.Nil(t, r.Deregister(infos[1]))
+	require.Empty(t, testServer.Instances(DefaultPolarisNamespace, "suite-registry-b"))
+}
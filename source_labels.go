@@ -0,0 +1,80 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+
+	"github.com/bytedance/gopkg/cloud/metainfo"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+)
+
+// SrcLabelFunc returns routing labels of a request.
+type SrcLabelFunc func(ctx context.Context) map[string]string
+
+// SrcLabels selects the attributes of a request added to ClientOptions.SrcMetadata as the source labels
+// matched by the routing rules, so the rules can route by user, tenant or canary flag.
+// A later source overrides an earlier one: the static metadata, metainfo, rpcinfo tags then Extractor.
+type SrcLabels struct {
+	// MetainfoKeys are the transient or persistent metainfo keys whose values are labels named by the keys.
+	MetainfoKeys []string `json:"metainfo_keys"`
+	// TagKeys are the tags of the callee rpcinfo, such as the ones set by callopt.WithTag, used as labels.
+	TagKeys []string `json:"tag_keys"`
+	// Extractor returns labels of the request, it must not modify the returned map afterwards.
+	Extractor SrcLabelFunc `json:"-"`
+}
+
+// srcMetadata returns the source labels of the request, static is not modified.
+func (sl SrcLabels) srcMetadata(ctx context.Context, static map[string]string) map[string]string {
+	if len(sl.MetainfoKeys) == 0 && len(sl.TagKeys) == 0 && sl.Extractor == nil {
+		return static
+	}
+	labels := make(map[string]string, len(static)+len(sl.MetainfoKeys)+len(sl.TagKeys))
+	for k, v := range static {
+		labels[k] = v
+	}
+	for _, key := range sl.MetainfoKeys {
+		if value, ok := metainfo.GetValue(ctx, key); ok {
+			labels[key] = value
+		} else if value, ok := metainfo.GetPersistentValue(ctx, key); ok {
+			labels[key] = value
+		}
+	}
+	if ri := rpcinfo.GetRPCInfo(ctx); ri != nil && len(sl.TagKeys) != 0 {
+		for _, key := range sl.TagKeys {
+			if value, ok := ri.To().Tag(key); ok {
+				labels[key] = value
+			}
+		}
+	}
+	if sl.Extractor != nil {
+		for k, v := range sl.Extractor(ctx) {
+			labels[k] = v
+		}
+	}
+	return labels
+}
+
+// withLabel returns a copy of labels with the label key set to value.
+func withLabel(labels map[string]string, key, value string) map[string]string {
+	copied := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		copied[k] = v
+	}
+	copied[key] = value
+	return copied
+}
@@ -0,0 +1,202 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/log"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+// serviceWatcher is the long-lived subscription of a service on a polaris client.
+// Events are only used as a signal, the instances are reloaded after each batch of events,
+// so events dropped or coalesced by the SDK never leave the watcher with a wrong instance list.
+type serviceWatcher struct {
+	key      model.ServiceKey
+	consumer api.ConsumerAPI
+	events   <-chan model.SubScribeEvent
+	refs     int
+	stop     chan struct{}
+
+	lock      sync.RWMutex
+	version   uint64
+	instances []model.Instance
+	changed   chan struct{}
+}
+
+// watchService returns the watcher of key, subscribing to the service if nobody watches it yet.
+// The caller must release the watcher with unwatchService.
+func (c *PolarisClient) watchService(consumer api.ConsumerAPI, key model.ServiceKey) (*serviceWatcher, error) {
+	c.watchLock.Lock()
+	defer c.watchLock.Unlock()
+
+	if w, ok := c.watchers[key]; ok {
+		w.refs++
+		return w, nil
+	}
+	watchReq := api.WatchServiceRequest{}
+	watchReq.Key = key
+	watchRsp, err := consumer.WatchService(&watchReq)
+	if err != nil {
+		return nil, err
+	}
+	w := &serviceWatcher{
+		key:       key,
+		consumer:  consumer,
+		events:    watchRsp.EventChannel,
+		refs:      1,
+		stop:      make(chan struct{}),
+		version:   1,
+		instances: watchRsp.GetAllInstancesResp.GetInstances(),
+		changed:   make(chan struct{}),
+	}
+	if c.watchers == nil {
+		c.watchers = make(map[model.ServiceKey]*serviceWatcher)
+	}
+	c.watchers[key] = w
+	go w.run()
+	return w, nil
+}
+
+// unwatchService releases a watcher returned by watchService, the subscription stops with the last user.
+// The polaris SDK has no way to cancel a subscription, it keeps the service in its cache
+// until the SDK context is destroyed.
+func (c *PolarisClient) unwatchService(w *serviceWatcher) {
+	c.watchLock.Lock()
+	defer c.watchLock.Unlock()
+
+	w.refs--
+	if w.refs == 0 {
+		delete(c.watchers, w.key)
+		close(w.stop)
+	}
+}
+
+func (w *serviceWatcher) run() {
+	for {
+		select {
+		case <-w.stop:
+			return
+		case _, ok := <-w.events:
+			if !ok {
+				return
+			}
+		}
+		// coalesce the events queued meanwhile
+		for drained := false; !drained; {
+			select {
+			case <-w.events:
+			default:
+				drained = true
+			}
+		}
+		w.reload()
+	}
+}
+
+// reload loads the instances of the service from the local cache of the SDK.
+func (w *serviceWatcher) reload() {
+	req := &api.GetAllInstancesRequest{}
+	req.Namespace = w.key.Namespace
+	req.Service = w.key.Service
+	rsp, err := w.consumer.GetAllInstances(req)
+	if err != nil {
+		pErr := newPolarisError("GetAllInstances", w.key.Namespace+":"+w.key.Service, err)
+		if !errors.Is(pErr, ErrServiceNotFound) {
+			log.GetBaseLogger().Errorf("[Polaris resolver] reload instances failed, err is %v", pErr)
+			return
+		}
+		rsp = &model.InstancesResponse{}
+	}
+	w.update(rsp.GetInstances())
+}
+
+func (w *serviceWatcher) update(instances []model.Instance) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.version++
+	w.instances = instances
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
+
+// state returns the current instances, their version and a channel closed on the next change.
+func (w *serviceWatcher) state() ([]model.Instance, uint64, <-chan struct{}) {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	return w.instances, w.version, w.changed
+}
+
+// watchSubscription is the use of a serviceWatcher by a resolver,
+// it remembers the last result returned by Watcher to compute the next change.
+type watchSubscription struct {
+	watcher *serviceWatcher
+	stopped chan struct{}
+
+	lock      sync.Mutex
+	version   uint64
+	delivered discovery.Result
+}
+
+// diffResult computes the change from prev to next, an instance is updated when its weight or revision changes.
+func diffResult(cacheKey string, prev, next discovery.Result) discovery.Change {
+	change := discovery.Change{
+		Result: discovery.Result{
+			Cacheable: next.Cacheable,
+			CacheKey:  cacheKey,
+			Instances: next.Instances,
+		},
+	}
+	prevMap := make(map[string]discovery.Instance, len(prev.Instances))
+	for _, ins := range prev.Instances {
+		prevMap[ins.Address().String()] = ins
+	}
+	nextMap := make(map[string]struct{}, len(next.Instances))
+	for _, ins := range next.Instances {
+		addr := ins.Address().String()
+		nextMap[addr] = struct{}{}
+		old, found := prevMap[addr]
+		if !found {
+			change.Added = append(change.Added, ins)
+		} else if instanceChanged(old, ins) {
+			change.Updated = append(change.Updated, ins)
+		}
+	}
+	for _, ins := range prev.Instances {
+		if _, found := nextMap[ins.Address().String()]; !found {
+			change.Removed = append(change.Removed, ins)
+		}
+	}
+	return change
+}
+
+func instanceChanged(prev, next discovery.Instance) bool {
+	if prev.Weight() != next.Weight() {
+		return true
+	}
+	prevIns, ok1 := prev.(*polarisKitexInstance)
+	nextIns, ok2 := next.(*polarisKitexInstance)
+	if !ok1 || !ok2 {
+		return false
+	}
+	return prevIns.polarisInstance.GetRevision() != nextIns.polarisInstance.GetRevision()
+}